@@ -0,0 +1,192 @@
+package qfpayslim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// batchChunkSize is the number of order numbers queried per request by
+// QueryBatchStream.
+const batchChunkSize = 50
+
+// ErrOrderNotFound is the error of a QueryResult whose order number was not
+// returned by QFPay.
+var ErrOrderNotFound = errors.New("qfpayslim: order not found")
+
+// QueryResult is the result of querying one order number in a batch: either
+// Response or Err is set.
+type QueryResult struct {
+	OutTradeNo string
+	Response   QueryResponse
+	Err        error
+}
+
+// QueryBatchStream queries the order numbers in chunks and sends a result
+// for each of them on the returned channel as soon as its chunk is done. If
+// a chunk fails, its order numbers get the error of the query; order numbers
+// QFPay does not know get ErrOrderNotFound. The channel is closed when all
+// results are sent or ctx is done.
+func (c *Client) QueryBatchStream(ctx context.Context, outTradeNos []string) <-chan QueryResult {
+	results := make(chan QueryResult, batchChunkSize)
+	go func() {
+		defer close(results)
+		outTradeNos := dedup(outTradeNos)
+		for len(outTradeNos) > 0 {
+			n := batchChunkSize
+			if n > len(outTradeNos) {
+				n = len(outTradeNos)
+			}
+			chunk := outTradeNos[:n]
+			outTradeNos = outTradeNos[n:]
+			responses, err := c.Query(ctx, chunk...)
+			if ctx.Err() != nil {
+				return
+			}
+			found := make(map[string]QueryResponse, len(responses))
+			for _, res := range responses {
+				found[res.OutTradeNo] = res
+			}
+			for _, outTradeNo := range chunk {
+				result := QueryResult{OutTradeNo: outTradeNo, Err: err}
+				if err == nil {
+					if res, ok := found[outTradeNo]; ok {
+						result.Response = res
+					} else {
+						result.Err = ErrOrderNotFound
+					}
+				}
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return results
+}
+
+type exportError struct {
+	OutTradeNo string `json:"out_trade_no"`
+	Error      string `json:"error"`
+}
+
+// ExportStatuses queries the order numbers in chunks and writes each
+// QueryResponse to w as a line of JSON as soon as its chunk is done. If a
+// chunk fails, or an order is not found, a line with the order number and
+// an "error" key is written instead. Only errors writing to w or from ctx
+// stop the export.
+func (c *Client) ExportStatuses(ctx context.Context, outTradeNos []string, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	enc := json.NewEncoder(w)
+	for result := range c.QueryBatchStream(ctx, outTradeNos) {
+		var line interface{} = result.Response
+		if result.Err != nil {
+			line = exportError{result.OutTradeNo, result.Err.Error()}
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// payManyConcurrency is the default maximum number of payments PayMany
+// creates at the same time.
+const payManyConcurrency = 5
+
+// PaymentOptions holds the arguments of MakePayment.
+type PaymentOptions struct {
+	PayType    string
+	OutTradeNo string
+	GoodsName  string
+	Cents      int
+	Extra      map[string]string
+}
+
+// PayManyOption configures PayMany.
+type PayManyOption func(*payManyConfig)
+
+type payManyConfig struct {
+	concurrency int
+	rate        int
+	interval    time.Duration
+}
+
+// WithConcurrency sets the maximum number of payments PayMany creates at
+// the same time, 5 by default. Values below 1 are ignored.
+func WithConcurrency(n int) PayManyOption {
+	return func(cfg *payManyConfig) {
+		if n > 0 {
+			cfg.concurrency = n
+		}
+	}
+}
+
+// WithRateLimit limits PayMany to starting at most n payments in any period
+// of interval, e.g. to stay below the request rate QFPay allows. Retries of
+// a payment do not count towards the limit. Values of n below 1 are ignored.
+func WithRateLimit(n int, interval time.Duration) PayManyOption {
+	return func(cfg *payManyConfig) {
+		if n > 0 {
+			cfg.rate, cfg.interval = n, interval
+		}
+	}
+}
+
+// PayMany creates the payments with bounded concurrency and, if
+// WithRateLimit is given, at a bounded rate. The responses and errors are in
+// the order of reqs: for each payment either its response or its error is
+// set. Retries follow the client's Retry policy. Once ctx is done, the
+// payments not yet started fail with its error.
+func (c *Client) PayMany(ctx context.Context, reqs []PaymentOptions, opts ...PayManyOption) ([]PaymentResponse, []error) {
+	cfg := payManyConfig{concurrency: payManyConcurrency}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	responses := make([]PaymentResponse, len(reqs))
+	errs := make([]error, len(reqs))
+	sem := make(chan struct{}, cfg.concurrency)
+	var starts []time.Time // start times of the last cfg.rate payments
+	var wg sync.WaitGroup
+	for i := range reqs {
+		if cfg.rate > 0 && len(starts) == cfg.rate {
+			select {
+			case <-time.After(time.Until(starts[0].Add(cfg.interval))):
+				starts = starts[1:]
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				continue
+			}
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+		if cfg.rate > 0 {
+			starts = append(starts, time.Now())
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			opts := reqs[i]
+			req, err := c.MakePayment(ctx, opts.PayType, opts.OutTradeNo, opts.GoodsName, opts.Cents, opts.Extra)
+			if err == nil {
+				err = req.Do(&responses[i])
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	return responses, errs
+}
@@ -0,0 +1,190 @@
+package qfpayslim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// paymentRequests returns n payments with the order numbers P0, P1, ...
+func paymentRequests(n int) []PaymentOptions {
+	reqs := make([]PaymentOptions, n)
+	for i := range reqs {
+		reqs[i] = PaymentOptions{PayType: PayTypeAlipayQRCode, OutTradeNo: "P" + strconv.Itoa(i), GoodsName: "Goods", Cents: 100}
+	}
+	return reqs
+}
+
+func TestPayManyConcurrency(t *testing.T) {
+	var inFlight, maxInFlight int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			peak := atomic.LoadInt32(&maxInFlight)
+			if n <= peak || atomic.CompareAndSwapInt32(&maxInFlight, peak, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		respondJSON(`{"respcd":"0000","out_trade_no":"`+r.FormValue("out_trade_no")+`"}`)(w, r)
+	})
+	reqs := paymentRequests(8)
+	responses, errs := c.PayMany(context.Background(), reqs, WithConcurrency(2))
+	for i := range reqs {
+		if errs[i] != nil {
+			t.Errorf("payment %d: %v", i, errs[i])
+		} else if responses[i].OutTradeNo != reqs[i].OutTradeNo {
+			t.Errorf("response %d is of %s, want %s", i, responses[i].OutTradeNo, reqs[i].OutTradeNo)
+		}
+	}
+	if peak := atomic.LoadInt32(&maxInFlight); peak > 2 {
+		t.Errorf("%d payments in flight, want at most 2", peak)
+	}
+}
+
+func TestPayManyRateLimit(t *testing.T) {
+	c := newTestClient(t, respondJSON(`{"respcd":"0000"}`))
+	start := time.Now()
+	_, errs := c.PayMany(context.Background(), paymentRequests(5), WithRateLimit(2, 50*time.Millisecond))
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("payment %d: %v", i, err)
+		}
+	}
+	// 2 payments start at once, 2 after 50ms and the last after 100ms.
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("5 payments at 2 per 50ms took %s, want at least 100ms", elapsed)
+	}
+}
+
+func TestPayManyCanceled(t *testing.T) {
+	c := newTestClient(t, respondJSON(`{"respcd":"0000"}`))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, errs := c.PayMany(ctx, paymentRequests(3))
+	for i, err := range errs {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("payment %d: err = %v, want context.Canceled", i, err)
+		}
+	}
+}
+
+// batchHandler answers queries with a paid record for each order number
+// except those starting with X, records the size of each query, and fails
+// queries for an order number starting with F.
+func batchHandler(sizes *[]int) http.HandlerFunc {
+	var mu sync.Mutex
+	return func(w http.ResponseWriter, r *http.Request) {
+		numbers := strings.Split(r.FormValue("out_trade_no"), ",")
+		mu.Lock()
+		*sizes = append(*sizes, len(numbers))
+		mu.Unlock()
+		var records []string
+		for _, n := range numbers {
+			if strings.HasPrefix(n, "F") {
+				respondJSON(`{"respcd":"1108","resperr":"Invalid parameters"}`)(w, r)
+				return
+			}
+			if !strings.HasPrefix(n, "X") {
+				records = append(records, `{"out_trade_no":"`+n+`","respcd":"0000"}`)
+			}
+		}
+		respondJSON(`{"respcd":"0000","data":[`+strings.Join(records, ",")+`]}`)(w, r)
+	}
+}
+
+func TestQueryBatchStream(t *testing.T) {
+	var sizes []int
+	c := newTestClient(t, batchHandler(&sizes))
+	var numbers []string
+	for i := 0; i < 120; i++ {
+		numbers = append(numbers, "P"+strconv.Itoa(i))
+	}
+	numbers = append(numbers, "X1", "P0", "X2")
+	var results []QueryResult
+	for result := range c.QueryBatchStream(context.Background(), numbers) {
+		results = append(results, result)
+	}
+	if want := []int{50, 50, 22}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("query sizes = %v, want %v", sizes, want)
+	}
+	if len(results) != 122 {
+		t.Fatalf("got %d results, want 122", len(results))
+	}
+	for i, result := range results[:120] {
+		if result.Err != nil || result.OutTradeNo != numbers[i] || result.Response.OutTradeNo != numbers[i] {
+			t.Errorf("result %d = %+v", i, result)
+		}
+	}
+	for _, result := range results[120:] {
+		if !errors.Is(result.Err, ErrOrderNotFound) {
+			t.Errorf("result of %s = %+v, want ErrOrderNotFound", result.OutTradeNo, result)
+		}
+	}
+}
+
+func TestQueryBatchStreamCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		cancel()
+		<-r.Context().Done()
+	})
+	results := c.QueryBatchStream(ctx, []string{"A1", "A2"})
+	select {
+	case result, ok := <-results:
+		if ok {
+			t.Errorf("got %+v after cancel, want the channel closed", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+}
+
+func TestExportStatuses(t *testing.T) {
+	var sizes []int
+	c := newTestClient(t, batchHandler(&sizes))
+	numbers := []string{"P1", "X1", "F1"}
+	for i := 0; i < 50; i++ {
+		numbers = append(numbers, "Q"+strconv.Itoa(i))
+	}
+	var buf bytes.Buffer
+	if err := c.ExportStatuses(context.Background(), numbers, &buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(numbers) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(numbers))
+	}
+	// the first chunk fails because of F1, the second succeeds
+	for i, line := range lines {
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		_, isError := v["error"]
+		if isError != (i < batchChunkSize) || v["out_trade_no"] != numbers[i] {
+			t.Errorf("line %d = %s", i, line)
+		}
+	}
+
+	sizes = nil
+	buf.Reset()
+	if err := c.ExportStatuses(context.Background(), []string{"P1", "X1"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `"out_trade_no":"P1"`
+	if lines := strings.Split(buf.String(), "\n"); !strings.Contains(lines[0], want) || lines[1] != `{"out_trade_no":"X1","error":"qfpayslim: order not found"}` {
+		t.Errorf("export = %s", buf.String())
+	}
+}
@@ -0,0 +1,106 @@
+package qfpayslim
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// DefaultAck is the response body QFPay expects once a notification has
+// been processed.
+var DefaultAck = []byte("SUCCESS")
+
+// callbackBodyLimit is the maximum size in bytes of the body of a
+// notification accepted by CallbackHandler.
+const callbackBodyLimit = 64 << 10
+
+// CallbackHandler is an http.Handler for the asynchronous notifications of
+// QFPay. It verifies the X-QF-SIGN header, decodes the notification and
+// passes it to the handler function, then acknowledges it. Bodies larger than
+// 64 KiB are rejected.
+type CallbackHandler struct {
+	client *Client
+	fn     func(QueryResponse) error
+	ack    []byte
+}
+
+// CallbackOption configures a CallbackHandler.
+type CallbackOption func(*CallbackHandler)
+
+// WithAck sets the response body written after a notification has been
+// processed, instead of DefaultAck. QFPay keeps resending a notification
+// until it receives the acknowledgment it expects, so a wrong ack results in
+// repeated notifications.
+func WithAck(ack []byte) CallbackOption {
+	return func(h *CallbackHandler) {
+		h.ack = ack
+	}
+}
+
+// NewCallbackHandler returns a handler that calls fn with every verified
+// notification. If fn returns an error, the notification is not
+// acknowledged so that QFPay sends it again.
+func (c *Client) NewCallbackHandler(fn func(QueryResponse) error, opts ...CallbackOption) *CallbackHandler {
+	h := &CallbackHandler{client: c, fn: fn, ack: DefaultAck}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *CallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, callbackBodyLimit))
+	if err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if !h.client.VerifyCallback(body, r.Header.Get("X-QF-SIGN")) {
+		http.Error(w, ErrInvalidSignature.Error(), http.StatusForbidden)
+		return
+	}
+	var res QueryResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.fn(res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(h.ack)
+}
+
+// ParseRefundCallback verifies the asynchronous notification of a refund,
+// with the raw request body and its X-QF-SIGN header, and decodes it. It
+// differs from the notification of a payment in that notify_type is
+// "refund" rather than "payment", out_trade_no and syssn are those of the
+// refund order, orig_syssn is the QFPay transaction number of the refunded
+// payment and txamt is the refunded amount. ErrInvalidSignature is returned
+// if the signature does not match Key or SecondaryKey, and a MismatchError
+// if the notification is not of a refund.
+func (c *Client) ParseRefundCallback(body []byte, sign string) (*RefundResponse, error) {
+	if !c.VerifyCallback(body, sign) {
+		return nil, ErrInvalidSignature
+	}
+	var notification struct {
+		NotifyType string `json:"notify_type"`
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, err
+	}
+	if notification.NotifyType != "" && notification.NotifyType != OrderTypeRefund {
+		return nil, MismatchError{"notify_type", OrderTypeRefund, notification.NotifyType}
+	}
+	var res QueryResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	refund := res.refundResponse()
+	return &refund, nil
+}
@@ -0,0 +1,122 @@
+package qfpayslim
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseRefundCallback(t *testing.T) {
+	c := &Client{Key: testKey}
+	body := []byte(`{"notify_type":"refund","syssn":"S2","orig_syssn":"S1","out_trade_no":"R1","respcd":"0000","txamt":"500","txcurrcd":"HKD"}`)
+	res, err := c.ParseRefundCallback(body, md5Sign(string(body)+testKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RefundResponse{OrigSyssn: "S1", OutTradeNo: "R1", Respcd: "0000", Syssn: "S2", Txamt: "500"}
+	if *res != want {
+		t.Errorf("res = %+v, want %+v", *res, want)
+	}
+	if _, err := c.ParseRefundCallback(body, md5Sign(string(body)+"OTHERKEY")); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("wrong signature: err = %v, want ErrInvalidSignature", err)
+	}
+	payment := []byte(`{"notify_type":"payment","syssn":"S1","out_trade_no":"A1","respcd":"0000"}`)
+	var mismatch MismatchError
+	if _, err := c.ParseRefundCallback(payment, md5Sign(string(payment)+testKey)); !errors.As(err, &mismatch) {
+		t.Errorf("payment notification: err = %v, want a MismatchError", err)
+	}
+}
+
+func TestCallbackHandler(t *testing.T) {
+	body := `{"notify_type":"payment","syssn":"S1","out_trade_no":"A1","respcd":"0000","txamt":"100"}`
+	tests := []struct {
+		name       string
+		body       string
+		sign       string
+		opts       []CallbackOption
+		fnErr      error
+		wantStatus int
+		wantBody   string
+		wantCalled bool
+	}{
+		{"valid", body, md5Sign(body + testKey), nil, nil, http.StatusOK, "SUCCESS", true},
+		{"custom ack", body, md5Sign(body + testKey), []CallbackOption{WithAck([]byte(`{"code":"SUCCESS"}`))}, nil, http.StatusOK, `{"code":"SUCCESS"}`, true},
+		{"bad signature", body, md5Sign(body + "OTHERKEY"), nil, nil, http.StatusForbidden, ErrInvalidSignature.Error() + "\n", false},
+		{"handler error", body, md5Sign(body + testKey), nil, errTest, http.StatusInternalServerError, errTest.Error() + "\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{Key: testKey}
+			called := false
+			h := c.NewCallbackHandler(func(res QueryResponse) error {
+				called = true
+				if res.Syssn != "S1" {
+					t.Errorf("Syssn = %q, want S1", res.Syssn)
+				}
+				return tt.fnErr
+			}, tt.opts...)
+			r := httptest.NewRequest("POST", "/callback", strings.NewReader(tt.body))
+			r.Header.Set("X-QF-SIGN", tt.sign)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("fn called = %v, want %v", called, tt.wantCalled)
+			}
+		})
+	}
+}
+
+func TestCallbackHandlerBodyLimit(t *testing.T) {
+	c := &Client{Key: testKey}
+	h := c.NewCallbackHandler(func(QueryResponse) error {
+		t.Error("fn called")
+		return nil
+	})
+	body := bytes.Repeat([]byte(" "), callbackBodyLimit+1)
+	r := httptest.NewRequest("POST", "/callback", bytes.NewReader(body))
+	r.Header.Set("X-QF-SIGN", md5Sign(string(body)+testKey))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestVerifyCallbackAmount(t *testing.T) {
+	c := &Client{Key: testKey}
+	tests := []struct {
+		name     string
+		body     string
+		key      string
+		cents    int
+		currency string
+		want     error
+	}{
+		{"valid", `{"txamt":"1050","txcurrcd":"HKD"}`, testKey, 1050, "HKD", nil},
+		{"JPY", `{"txamt":"10","txcurrcd":"JPY"}`, testKey, 1000, "JPY", nil},
+		{"bad signature", `{"txamt":"1050","txcurrcd":"HKD"}`, "OTHERKEY", 1050, "HKD", ErrInvalidSignature},
+		{"currency", `{"txamt":"1050","txcurrcd":"CNY"}`, testKey, 1050, "HKD", MismatchError{"txcurrcd", "HKD", "CNY"}},
+		{"amount", `{"txamt":"1050","txcurrcd":"HKD"}`, testKey, 1000, "HKD", MismatchError{"txamt", "1000", "1050"}},
+		{"JPY amount", `{"txamt":"10","txcurrcd":"JPY"}`, testKey, 10, "JPY", MismatchError{"txamt", "10", "1000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := c.VerifyCallbackAmount([]byte(tt.body), md5Sign(tt.body+tt.key), tt.cents, tt.currency)
+			if err != tt.want {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+			if ok != (tt.want == nil) {
+				t.Errorf("ok = %v, want %v", ok, tt.want == nil)
+			}
+		})
+	}
+}
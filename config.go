@@ -0,0 +1,102 @@
+package qfpayslim
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default API prefixes of the production and test environments.
+const (
+	ProductionPrefix = "https://openapi-hk.qfapi.com"
+	TestPrefix       = "https://test-openapi-hk.qfapi.com"
+)
+
+// Credentials holds the API prefix and credentials of one environment.
+type Credentials struct {
+	Prefix  string // defaults to ProductionPrefix or TestPrefix
+	AppCode string
+	Key     string
+}
+
+// Config holds the credentials of both the production and the test
+// environment, so that the right pair is always used with the right prefix.
+type Config struct {
+	Production Credentials
+	Test       Credentials
+}
+
+// Client returns a new client for the production environment if production
+// is true, or for the test environment otherwise.
+func (cfg Config) Client(production bool) *Client {
+	creds, prefix := cfg.Test, TestPrefix
+	if production {
+		creds, prefix = cfg.Production, ProductionPrefix
+	}
+	if creds.Prefix != "" {
+		prefix = creds.Prefix
+	}
+	return &Client{
+		Prefix:  prefix,
+		AppCode: creds.AppCode,
+		Key:     creds.Key,
+	}
+}
+
+// NewClientFromEnv creates a client from the environment variables
+// QFPAY_PREFIX, QFPAY_APPCODE and QFPAY_KEY, which are required, and
+// QFPAY_DEBUG, which is optional and parsed by strconv.ParseBool.
+func NewClientFromEnv() (*Client, error) {
+	c := &Client{
+		Prefix:  os.Getenv("QFPAY_PREFIX"),
+		AppCode: os.Getenv("QFPAY_APPCODE"),
+		Key:     os.Getenv("QFPAY_KEY"),
+	}
+	var missing []string
+	for name, value := range map[string]string{
+		"QFPAY_PREFIX":  c.Prefix,
+		"QFPAY_APPCODE": c.AppCode,
+		"QFPAY_KEY":     c.Key,
+	} {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, ValidationError{"missing environment variables: " + strings.Join(missing, ", ")}
+	}
+	if debug := os.Getenv("QFPAY_DEBUG"); debug != "" {
+		v, err := strconv.ParseBool(debug)
+		if err != nil {
+			return nil, ValidationError{"invalid QFPAY_DEBUG: " + debug}
+		}
+		c.Debug = v
+	}
+	return c, nil
+}
+
+// Validate checks the configuration of the client, so that mistakes can be
+// caught at startup rather than at the first request. All problems found are
+// returned together.
+func (c *Client) Validate() error {
+	var errs []error
+	if u, err := url.Parse(c.Prefix); err != nil || u.Host == "" {
+		errs = append(errs, validationErrorf("invalid Prefix %q", c.Prefix))
+	} else if u.Scheme != "https" && !c.AllowInsecure {
+		errs = append(errs, ErrInsecurePrefix)
+	}
+	if len(c.AppCode) != 32 {
+		errs = append(errs, validationErrorf("AppCode must be 32 characters, got %d", len(c.AppCode)))
+	}
+	if len(c.Key) != 32 {
+		errs = append(errs, validationErrorf("Key must be 32 characters, got %d", len(c.Key)))
+	}
+	if c.SecondaryKey != "" && len(c.SecondaryKey) != 32 {
+		errs = append(errs, validationErrorf("SecondaryKey must be 32 characters, got %d", len(c.SecondaryKey)))
+	}
+	return errors.Join(errs...)
+}
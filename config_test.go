@@ -0,0 +1,63 @@
+package qfpayslim
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() *Client {
+		return &Client{Prefix: TestPrefix, AppCode: testAppCode, Key: testKey}
+	}
+	tests := []struct {
+		name   string
+		modify func(*Client)
+		want   []string
+	}{
+		{"valid", func(c *Client) {}, nil},
+		{"valid with secondary key", func(c *Client) { c.SecondaryKey = testAppCode }, nil},
+		{"local prefix allowed", func(c *Client) { c.Prefix, c.AllowInsecure = "http://127.0.0.1:8080", true }, nil},
+		{"empty prefix", func(c *Client) { c.Prefix = "" }, []string{`invalid Prefix ""`}},
+		{"prefix without host", func(c *Client) { c.Prefix = "openapi-hk.qfapi.com" }, []string{"invalid Prefix"}},
+		{"insecure prefix", func(c *Client) { c.Prefix = "http://openapi-hk.qfapi.com" }, []string{ErrInsecurePrefix.Error()}},
+		{"short app code", func(c *Client) { c.AppCode = "ABC" }, []string{"AppCode must be 32 characters, got 3"}},
+		{"short key", func(c *Client) { c.Key = "" }, []string{"Key must be 32 characters, got 0"}},
+		{"short secondary key", func(c *Client) { c.SecondaryKey = "OLD" }, []string{"SecondaryKey must be 32 characters, got 3"}},
+		{"several problems", func(c *Client) { c.Prefix, c.AppCode, c.Key = "http://x", "", "" }, []string{
+			ErrInsecurePrefix.Error(), "AppCode must be 32 characters", "Key must be 32 characters",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(c)
+			err := c.Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate = nil, want %q", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate = %q, want it to contain %q", err, want)
+				}
+			}
+			if got := len(strings.Split(err.Error(), "\n")); got != len(tt.want) {
+				t.Errorf("Validate returned %d problems, want %d: %v", got, len(tt.want), err)
+			}
+			if ClassifyError(err) != KindValidation {
+				t.Errorf("ClassifyError(Validate) = %s, want validation", ClassifyError(err))
+			}
+		})
+	}
+	c := valid()
+	c.Prefix = "http://openapi-hk.qfapi.com"
+	if err := c.Validate(); !errors.Is(err, ErrInsecurePrefix) {
+		t.Errorf("Validate = %v, want ErrInsecurePrefix", err)
+	}
+}
@@ -0,0 +1,68 @@
+package qfpayslim
+
+import (
+	"strconv"
+	"strings"
+)
+
+// minorUnits lists the currencies whose minor unit is not 1/100, by number
+// of decimal digits, per ISO 4217.
+var minorUnits = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// MinorUnits returns the number of decimal digits of currency, e.g. 2 for
+// HKD and 0 for JPY.
+func MinorUnits(currency string) int {
+	if n, ok := minorUnits[strings.ToUpper(currency)]; ok {
+		return n
+	}
+	return 2
+}
+
+// minorAmount converts cents, 1/100 of a unit, into the minor unit of
+// currency as sent in txamt. An error is returned if cents has more
+// precision than the currency allows, e.g. 150 cents of JPY.
+func minorAmount(cents int, currency string) (int, error) {
+	n := MinorUnits(currency)
+	for ; n < 2; n++ {
+		if cents%10 != 0 {
+			return 0, validationErrorf("%d cents is not a whole amount of %s", cents, currency)
+		}
+		cents /= 10
+	}
+	for ; n > 2; n-- {
+		cents *= 10
+	}
+	return cents, nil
+}
+
+// centsAmount converts amount, in the minor unit of currency as received in
+// txamt, into cents. An error is returned if amount is not a whole number of
+// cents, e.g. 1005 fils of KWD.
+func centsAmount(amount int, currency string) (int, error) {
+	cents := amount
+	n := MinorUnits(currency)
+	for ; n < 2; n++ {
+		cents *= 10
+	}
+	for ; n > 2; n-- {
+		if cents%10 != 0 {
+			return 0, validationErrorf("%d is not a whole number of cents of %s", amount, currency)
+		}
+		cents /= 10
+	}
+	return cents, nil
+}
+
+// parseCents parses txamt, an amount in the minor unit of currency, into
+// cents.
+func parseCents(txamt, currency string) (int, error) {
+	amount, err := strconv.Atoi(txamt)
+	if err != nil {
+		return 0, err
+	}
+	return centsAmount(amount, currency)
+}
@@ -0,0 +1,73 @@
+package qfpayslim
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestMinorAmount(t *testing.T) {
+	tests := []struct {
+		cents    int
+		currency string
+		want     int
+		wantErr  bool
+	}{
+		{1050, "HKD", 1050, false},
+		{1000, "JPY", 10, false},
+		{1000, "jpy", 10, false},
+		{150, "JPY", 0, true},
+		{1050, "KWD", 10500, false},
+	}
+	for _, tt := range tests {
+		got, err := minorAmount(tt.cents, tt.currency)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("minorAmount(%d, %s) = %d, %v, want %d", tt.cents, tt.currency, got, err, tt.want)
+		}
+		if tt.wantErr {
+			continue
+		}
+		if cents, err := centsAmount(got, tt.currency); err != nil || cents != tt.cents {
+			t.Errorf("centsAmount(%d, %s) = %d, %v, want %d", got, tt.currency, cents, err, tt.cents)
+		}
+	}
+	if _, err := centsAmount(10505, "KWD"); err == nil {
+		t.Error("centsAmount(10505, KWD) returned no error")
+	}
+}
+
+func TestMakePaymentCurrency(t *testing.T) {
+	var txamt, txcurrcd string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		txamt, txcurrcd = r.FormValue("txamt"), r.FormValue("txcurrcd")
+		respondJSON(`{"respcd":"0000"}`)(w, r)
+	})
+	ctx := context.Background()
+	tests := []struct {
+		cents    int
+		extra    map[string]string
+		want     string
+		currency string
+	}{
+		{1050, nil, "1050", "HKD"},
+		{1000, map[string]string{"txcurrcd": "JPY"}, "10", "JPY"},
+	}
+	for _, tt := range tests {
+		req, err := c.MakePayment(ctx, PayTypeAlipayQRCode, "A1", "Goods", tt.cents, tt.extra)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := req.Do(); err != nil {
+			t.Fatal(err)
+		}
+		if txamt != tt.want || txcurrcd != tt.currency {
+			t.Errorf("sent txamt %s %s, want %s %s", txamt, txcurrcd, tt.want, tt.currency)
+		}
+	}
+	if _, err := c.MakePayment(ctx, PayTypeAlipayQRCode, "A1", "Goods", 150, map[string]string{"txcurrcd": "JPY"}); ClassifyError(err) != KindValidation {
+		t.Errorf("MakePayment of 150 cents of JPY = %v, want a ValidationError", err)
+	}
+	if _, _, err := c.BuildPaymentForm(PayTypeAlipayQRCode, "A1", "Goods", 150, map[string]string{"txcurrcd": "JPY"}); ClassifyError(err) != KindValidation {
+		t.Errorf("BuildPaymentForm of 150 cents of JPY = %v, want a ValidationError", err)
+	}
+}
@@ -0,0 +1,237 @@
+package qfpayslim
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// DoInto sends the request and decodes the value at path of the response
+// into a value of type T. The path uses the same dot-separated keys as Do,
+// with "*" matching every element of an array:
+//
+//	qrcode, err := qfpayslim.DoInto[string](req, "qrcode")
+//	responses, err := qfpayslim.DoInto[[]qfpayslim.QueryResponse](req, "data.*")
+//
+// Unlike Do, an error is returned if the value cannot be decoded into T.
+func DoInto[T any](req *Request, path string) (T, error) {
+	var v T
+	var b []byte
+	if err := req.Do(&b); err != nil {
+		return v, err
+	}
+	data, err := extract(b, strings.Split(path, "."))
+	if err != nil {
+		return v, err
+	}
+	if err := req.client.unmarshal(data, &v); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// extract returns the JSON value at keys of data. Values matched by "*" are
+// collected into an array.
+func extract(data json.RawMessage, keys []string) (json.RawMessage, error) {
+	for i, key := range keys {
+		if key == "" {
+			continue
+		}
+		if key == "*" {
+			var items []json.RawMessage
+			if err := json.Unmarshal(data, &items); err != nil {
+				return nil, fmt.Errorf("qfpayslim: %s is not an array: %w", strings.Join(keys[:i], "."), err)
+			}
+			out := make([]json.RawMessage, len(items))
+			for n := range items {
+				item, err := extract(items[n], keys[i+1:])
+				if err != nil {
+					return nil, err
+				}
+				out[n] = item
+			}
+			return json.Marshal(out)
+		}
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, fmt.Errorf("qfpayslim: %s is not an object: %w", strings.Join(keys[:i], "."), err)
+		}
+		v, ok := obj[key]
+		if !ok {
+			return nil, fmt.Errorf("qfpayslim: key %s not found", strings.Join(keys[:i+1], "."))
+		}
+		data = v
+	}
+	return data, nil
+}
+
+// DoValidated is like Do with a single struct destination, and returns the
+// required fields that are missing or empty after decoding. Fields can be
+// named by their Go name or JSON key:
+//
+//	var res qfpayslim.QueryResponse
+//	missing, err := req.DoValidated(&res, "syssn", "txamt")
+func (req *Request) DoValidated(dest interface{}, required ...string) ([]string, error) {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, validationErrorf("destination must be a pointer to a struct, got %T", dest)
+	}
+	if err := req.Do(dest); err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, name := range required {
+		field, ok := fieldByName(v.Elem(), name)
+		if !ok || field.IsZero() {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// fieldByName returns the field of the struct v with the Go name or JSON key
+// name.
+func fieldByName(v reflect.Value, name string) (reflect.Value, bool) {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		key := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.IsExported() && (field.Name == name || key == name) {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// fieldAliaser is implemented by types whose UnmarshalJSON accepts other
+// keys for their fields, such as QueryResponse.
+type fieldAliaser interface {
+	fieldAliases() map[string]string
+}
+
+var (
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	aliaserType     = reflect.TypeOf((*fieldAliaser)(nil)).Elem()
+)
+
+// checkUnknownFields returns an error for the first key of the JSON data,
+// at any depth, that has no field in typ. It is what StrictJSON checks,
+// since json.Decoder.DisallowUnknownFields misses the fields of types with
+// their own UnmarshalJSON and the values decoded by arrange. Such types are
+// only checked if they implement fieldAliaser; otherwise they are trusted to
+// handle their fields.
+func checkUnknownFields(data []byte, typ reflect.Type) error {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	ptr := reflect.PtrTo(typ)
+	if ptr.Implements(unmarshalerType) && !ptr.Implements(aliaserType) {
+		return nil
+	}
+	switch typ.Kind() {
+	case reflect.Struct:
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(data, &obj) != nil {
+			return nil
+		}
+		var aliases map[string]string
+		if a, ok := reflect.New(typ).Interface().(fieldAliaser); ok {
+			aliases = a.fieldAliases()
+		}
+		for key, value := range obj {
+			name := key
+			if canonical, ok := aliases[key]; ok {
+				name = canonical
+			}
+			field, ok := jsonField(typ, name)
+			if !ok {
+				return validationErrorf("unknown field %q in %s", key, typ.Name())
+			}
+			if err := checkUnknownFields(value, field.Type); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		var items []json.RawMessage
+		if json.Unmarshal(data, &items) != nil {
+			return nil
+		}
+		for _, item := range items {
+			if err := checkUnknownFields(item, typ.Elem()); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(data, &obj) != nil {
+			return nil
+		}
+		for _, value := range obj {
+			if err := checkUnknownFields(value, typ.Elem()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonField returns the field of the struct type typ that encoding/json
+// decodes the key into, including the fields of embedded structs.
+func jsonField(typ reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if f, ok := jsonField(embedded, key); ok {
+					return f, true
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		name := tag
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// checkArranged checks the value at key of the JSON data for unknown fields
+// of target, the destination of arrange. Each "*" in key adds an array
+// around the element type of target, as collected by extract. Keys that do
+// not resolve are left to arrange.
+func checkArranged(data []byte, target interface{}, key string) error {
+	keys := strings.Split(key, ".")
+	value, err := extract(data, keys)
+	if err != nil {
+		return nil
+	}
+	typ := reflect.TypeOf(target).Elem()
+	if typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	for _, k := range keys {
+		if k == "*" {
+			typ = reflect.SliceOf(typ)
+		}
+	}
+	return checkUnknownFields(value, typ)
+}
@@ -0,0 +1,95 @@
+package qfpayslim
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const decodeTestResponse = `{"respcd":"0000","qrcode":"https://qr.example/1","data":[
+	{"out_trade_no":"A1","txamt":"100"},
+	{"out_trade_no":"A2","txamt":"200"}
+]}`
+
+func TestDoInto(t *testing.T) {
+	c := newTestClient(t, respondJSON(decodeTestResponse))
+	newRequest := func() *Request {
+		req, err := c.NewFormRequest(context.Background(), "/trade/v1/query", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	qrcode, err := DoInto[string](newRequest(), "qrcode")
+	if err != nil || qrcode != "https://qr.example/1" {
+		t.Errorf("DoInto[string] = %q, %v", qrcode, err)
+	}
+
+	first, err := DoInto[QueryResponse](newRequest(), "data.*")
+	if err == nil {
+		t.Errorf("DoInto[QueryResponse] of an array = %+v, want an error", first)
+	}
+
+	responses, err := DoInto[[]QueryResponse](newRequest(), "data.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []QueryResponse{{OutTradeNo: "A1", Txamt: "100"}, {OutTradeNo: "A2", Txamt: "200"}}
+	if !reflect.DeepEqual(responses, want) {
+		t.Errorf("DoInto[[]QueryResponse] = %+v, want %+v", responses, want)
+	}
+
+	numbers, err := DoInto[[]string](newRequest(), "data.*.out_trade_no")
+	if err != nil || !reflect.DeepEqual(numbers, []string{"A1", "A2"}) {
+		t.Errorf("DoInto[[]string] = %q, %v", numbers, err)
+	}
+
+	res, err := DoInto[struct {
+		Qrcode string `json:"qrcode"`
+	}](newRequest(), "")
+	if err != nil || res.Qrcode != "https://qr.example/1" {
+		t.Errorf("DoInto[struct] = %+v, %v", res, err)
+	}
+
+	for _, path := range []string{"missing", "data.*.missing", "qrcode.url"} {
+		if _, err := DoInto[string](newRequest(), path); err == nil {
+			t.Errorf("DoInto(%q) returned no error", path)
+		} else if !strings.HasPrefix(err.Error(), "qfpayslim: ") {
+			t.Errorf("DoInto(%q) error = %q", path, err)
+		}
+	}
+}
+
+func TestDoValidated(t *testing.T) {
+	c := newTestClient(t, respondJSON(`{"respcd":"0000","syssn":"S1","txamt":"","out_trade_no":"A1"}`))
+	newRequest := func() *Request {
+		req, err := c.NewFormRequest(context.Background(), "/trade/v1/payment", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	var res PaymentResponse
+	missing, err := newRequest().DoValidated(&res, "syssn", "OutTradeNo", "txamt", "QRCode", "nonexistent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"txamt", "QRCode", "nonexistent"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("missing = %q, want %q", missing, want)
+	}
+	if res.Syssn != "S1" {
+		t.Errorf("Syssn = %q, want S1", res.Syssn)
+	}
+	if missing, err := newRequest().DoValidated(&res, "syssn"); err != nil || missing != nil {
+		t.Errorf("DoValidated = %q, %v, want nothing missing", missing, err)
+	}
+	var validationErr ValidationError
+	for _, dest := range []interface{}{res, (*PaymentResponse)(nil), new(string)} {
+		if _, err := newRequest().DoValidated(dest); !errors.As(err, &validationErr) {
+			t.Errorf("DoValidated(%T) = %v, want a ValidationError", dest, err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package qfpayslim
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// ErrDeepLinkUnsupported is returned by BuildWalletDeepLink for pay types
+// without a documented deep link scheme, such as WeChat Pay, which only
+// allows opening its scanner.
+var ErrDeepLinkUnsupported = errors.New("qfpayslim: pay type has no deep link")
+
+// BuildWalletDeepLink returns a URL that opens the wallet app of payType
+// directly at the payment of qrPayload, the qrcode returned by an MPM
+// payment, so that mobile users do not have to scan it:
+//
+//   - PayTypeAlipayQRCode opens Alipay with the alipays:// scheme.
+//   - PayTypePayMeQRCode payloads are already universal links that open
+//     PayMe, so they are returned as is.
+func BuildWalletDeepLink(payType string, qrPayload string) (string, error) {
+	if qrPayload == "" {
+		return "", ValidationError{"empty QR code payload"}
+	}
+	switch payType {
+	case PayTypeAlipayQRCode:
+		return "alipays://platformapi/startapp?saId=10000007&qrcode=" + url.QueryEscape(qrPayload), nil
+	case PayTypePayMeQRCode:
+		if strings.HasPrefix(qrPayload, "https://") {
+			return qrPayload, nil
+		}
+	}
+	return "", ErrDeepLinkUnsupported
+}
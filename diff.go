@@ -0,0 +1,42 @@
+package qfpayslim
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// FieldDiff is a field that differs between two QueryResponses.
+type FieldDiff struct {
+	Field string // Go field name, e.g. Respcd
+	Key   string // JSON key, e.g. respcd
+	Old   string
+	New   string
+}
+
+// DiffQueryResponse returns the fields that differ from a to b, e.g. between
+// two snapshots of the same order taken before and after a refund. Fields
+// are compared by reflection, so fields added to QueryResponse are included
+// automatically.
+func DiffQueryResponse(a, b QueryResponse) []FieldDiff {
+	var diffs []FieldDiff
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	typ := va.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		x, y := va.Field(i).Interface(), vb.Field(i).Interface()
+		if reflect.DeepEqual(x, y) {
+			continue
+		}
+		diffs = append(diffs, FieldDiff{
+			Field: field.Name,
+			Key:   strings.Split(field.Tag.Get("json"), ",")[0],
+			Old:   fmt.Sprint(x),
+			New:   fmt.Sprint(y),
+		})
+	}
+	return diffs
+}
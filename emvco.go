@@ -0,0 +1,192 @@
+package qfpayslim
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fpsGUID identifies the FPS merchant account information template of an
+// EMVCo QR code.
+const fpsGUID = "hk.com.hkicl"
+
+// emvcoCurrencies maps currency codes to the ISO 4217 numeric codes used in
+// EMVCo QR codes.
+var emvcoCurrencies = map[string]string{
+	"HKD": "344",
+	"CNY": "156",
+}
+
+// FPSQRCode is a static FPS QR code that a shop can print, for payments made
+// to the FPS account directly rather than through /trade/v1/payment. One of
+// FPSID, Mobile or Email identifies the account.
+type FPSQRCode struct {
+	FPSID        string // FPS identifier of the account
+	Mobile       string // mobile number registered to the account, e.g. +852-61234567
+	Email        string // email address registered to the account
+	MerchantName string // shown to the payer
+	MerchantCity string // defaults to HK
+	Cents        int    // fixed amount in cents, 0 lets the payer enter it
+	Currency     string // HKD or CNY, defaults to HKD
+}
+
+// Payload returns the EMVCo payload of the QR code, to be encoded as a QR
+// code image. An error is returned if a value does not fit in a data object,
+// which holds at most 99 bytes including the nested objects of the merchant
+// account.
+func (q FPSQRCode) Payload() (string, error) {
+	var account [2]string
+	switch {
+	case q.FPSID != "":
+		account = [2]string{"02", q.FPSID}
+	case q.Mobile != "":
+		account = [2]string{"03", q.Mobile}
+	case q.Email != "":
+		account = [2]string{"04", q.Email}
+	default:
+		return "", ValidationError{"one of FPS ID, mobile or email is required"}
+	}
+	if q.MerchantName == "" || len(q.MerchantName) > 25 {
+		return "", ValidationError{"merchant name is required and must be at most 25 bytes"}
+	}
+	if q.Cents < 0 {
+		return "", validationErrorf("amount must not be negative, got %d", q.Cents)
+	}
+	currency := q.Currency
+	if currency == "" {
+		currency = "HKD"
+	}
+	numeric, ok := emvcoCurrencies[strings.ToUpper(currency)]
+	if !ok {
+		return "", validationErrorf("unsupported FPS currency %s", currency)
+	}
+	city := q.MerchantCity
+	if city == "" {
+		city = "HK"
+	} else if len(city) > 15 {
+		return "", ValidationError{"merchant city must be at most 15 bytes"}
+	}
+	template, err := encodeTLV([][2]string{{"00", fpsGUID}, account})
+	if err != nil {
+		return "", err
+	}
+	objects := [][2]string{{"00", "01"}, {"01", "11"}, {"26", template}, {"52", "0000"}, {"53", numeric}}
+	if q.Cents > 0 {
+		objects = append(objects, [2]string{"54", fmt.Sprintf("%d.%02d", q.Cents/100, q.Cents%100)})
+	}
+	objects = append(objects, [2]string{"58", "HK"}, [2]string{"59", q.MerchantName}, [2]string{"60", city})
+	payload, err := encodeTLV(objects)
+	if err != nil {
+		return "", err
+	}
+	payload += "6304"
+	return payload + crc16(payload), nil
+}
+
+// ErrInvalidCRC is returned by ParseEMVCo if the CRC of the payload does not
+// match its content.
+var ErrInvalidCRC = errors.New("qfpayslim: invalid EMVCo payload CRC")
+
+// EMVCoPayload is a decoded EMVCo QR code payload.
+type EMVCoPayload struct {
+	Fields           map[string]string            // all top-level data objects by ID
+	MerchantAccounts map[string]map[string]string // merchant account templates (IDs 26 to 51) by ID, then sub ID
+	Static           bool                         // point of initiation is static (11) rather than dynamic (12)
+	CategoryCode     string                       // merchant category code
+	Currency         string                       // ISO 4217 numeric currency code, e.g. 344 for HKD
+	Amount           string                       // transaction amount, empty if the payer enters it
+	CountryCode      string                       // country code of the merchant
+	MerchantName     string                       // merchant name
+	MerchantCity     string                       // merchant city
+	CRC              string                       // checksum, verified by ParseEMVCo
+}
+
+// ParseEMVCo decodes an EMVCo QR code payload, such as the qrcode returned
+// for a payment or a scanned FPS code, and verifies its CRC.
+func ParseEMVCo(payload string) (*EMVCoPayload, error) {
+	fields, err := parseTLV(payload)
+	if err != nil {
+		return nil, err
+	}
+	crc, ok := fields["63"]
+	if !ok || !strings.HasSuffix(payload, "6304"+crc) {
+		return nil, ValidationError{"EMVCo payload does not end with a CRC"}
+	}
+	if !strings.EqualFold(crc16(payload[:len(payload)-len(crc)]), crc) {
+		return nil, ErrInvalidCRC
+	}
+	p := &EMVCoPayload{
+		Fields:           fields,
+		MerchantAccounts: map[string]map[string]string{},
+		Static:           fields["01"] == "11",
+		CategoryCode:     fields["52"],
+		Currency:         fields["53"],
+		Amount:           fields["54"],
+		CountryCode:      fields["58"],
+		MerchantName:     fields["59"],
+		MerchantCity:     fields["60"],
+		CRC:              crc,
+	}
+	for id, value := range fields {
+		if n, _ := strconv.Atoi(id); n < 26 || n > 51 {
+			continue
+		}
+		account, err := parseTLV(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w in merchant account %s", err, id)
+		}
+		p.MerchantAccounts[id] = account
+	}
+	return p, nil
+}
+
+// parseTLV decodes the data objects of an EMVCo payload or template.
+func parseTLV(data string) (map[string]string, error) {
+	fields := map[string]string{}
+	for i := 0; i < len(data); {
+		if i+4 > len(data) {
+			return nil, validationErrorf("truncated EMVCo data object at %d", i)
+		}
+		id := data[i : i+2]
+		n, err := strconv.Atoi(data[i+2 : i+4])
+		if err != nil || n < 0 || i+4+n > len(data) {
+			return nil, validationErrorf("invalid length of EMVCo data object %s at %d", id, i)
+		}
+		fields[id] = data[i+4 : i+4+n]
+		i += 4 + n
+	}
+	return fields, nil
+}
+
+// encodeTLV encodes the data objects, given as pairs of ID and value, of an
+// EMVCo payload or template. An error is returned for values longer than
+// the 99 bytes allowed by the two-digit length.
+func encodeTLV(objects [][2]string) (string, error) {
+	var b strings.Builder
+	for _, object := range objects {
+		id, value := object[0], object[1]
+		if len(value) > 99 {
+			return "", validationErrorf("EMVCo data object %s is %d bytes, at most 99 are allowed", id, len(value))
+		}
+		fmt.Fprintf(&b, "%s%02d%s", id, len(value), value)
+	}
+	return b.String(), nil
+}
+
+// crc16 returns the CRC-16/CCITT-FALSE checksum of an EMVCo payload as four
+// uppercase hex digits.
+func crc16(payload string) string {
+	crc := uint16(0xffff)
+	for i := 0; i < len(payload); i++ {
+		crc ^= uint16(payload[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%04X", crc)
+}
@@ -0,0 +1,64 @@
+package qfpayslim
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCRC16(t *testing.T) {
+	if got := crc16("123456789"); got != "29B1" {
+		t.Errorf("crc16 = %s, want 29B1", got)
+	}
+}
+
+func TestFPSQRCode(t *testing.T) {
+	payload, err := FPSQRCode{FPSID: "1234567", MerchantName: "Shop", Cents: 1050}.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "000201010211" + "2627" + "0012hk.com.hkicl" + "02071234567" + "52040000" + "5303344" + "540510.50" + "5802HK" + "5904Shop" + "6002HK" + "63049589"
+	if payload != want {
+		t.Errorf("Payload = %s, want %s", payload, want)
+	}
+	p, err := ParseEMVCo(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Amount != "10.50" || p.Currency != "344" || p.MerchantName != "Shop" || !p.Static {
+		t.Errorf("ParseEMVCo = %+v", p)
+	}
+	if id := p.MerchantAccounts["26"]["02"]; id != "1234567" {
+		t.Errorf("FPS ID = %q, want 1234567", id)
+	}
+	if _, err := ParseEMVCo(payload[:len(payload)-4] + "0000"); !errors.Is(err, ErrInvalidCRC) {
+		t.Errorf("ParseEMVCo with a wrong CRC = %v, want ErrInvalidCRC", err)
+	}
+}
+
+func TestFPSQRCodeLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		code FPSQRCode
+	}{
+		{"no account", FPSQRCode{MerchantName: "Shop"}},
+		{"no merchant name", FPSQRCode{FPSID: "1234567"}},
+		{"long merchant name", FPSQRCode{FPSID: "1234567", MerchantName: strings.Repeat("a", 26)}},
+		{"long merchant city", FPSQRCode{FPSID: "1234567", MerchantName: "Shop", MerchantCity: strings.Repeat("a", 16)}},
+		{"negative amount", FPSQRCode{FPSID: "1234567", MerchantName: "Shop", Cents: -1}},
+		{"unsupported currency", FPSQRCode{FPSID: "1234567", MerchantName: "Shop", Currency: "USD"}},
+		{"long email", FPSQRCode{Email: strings.Repeat("a", 70) + "@example.com", MerchantName: "Shop"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var validationErr ValidationError
+			if _, err := tt.code.Payload(); !errors.As(err, &validationErr) {
+				t.Errorf("Payload = %v, want a ValidationError", err)
+			}
+		})
+	}
+	email := strings.Repeat("a", 67) + "@example.com" // the template is 16 + 4 + 79 = 99 bytes
+	if _, err := (FPSQRCode{Email: email, MerchantName: "Shop"}).Payload(); err != nil {
+		t.Errorf("Payload with a 99-byte template = %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package qfpayslim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ValidationError is returned when the input of a request, or a response
+// checked against the input, is invalid.
+type ValidationError struct {
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return "qfpayslim: " + e.Message
+}
+
+func validationErrorf(format string, a ...interface{}) error {
+	return ValidationError{fmt.Sprintf(format, a...)}
+}
+
+// ErrorKind is the category of an error returned by this package.
+type ErrorKind int
+
+const (
+	KindNone       ErrorKind = iota // no error
+	KindTransport                   // network failure or unexpected response
+	KindBusiness                    // error returned by QFPay (QFError)
+	KindValidation                  // invalid input or mismatched response
+	KindCanceled                    // context canceled or deadline exceeded
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindNone:
+		return "none"
+	case KindTransport:
+		return "transport"
+	case KindBusiness:
+		return "business"
+	case KindValidation:
+		return "validation"
+	case KindCanceled:
+		return "canceled"
+	}
+	return "unknown"
+}
+
+// validationErrors lists the sentinel errors of the package classified as
+// KindValidation. Add new sentinel errors here or to ClassifyError, so that
+// they are not mistaken for transport errors.
+var validationErrors = []error{
+	ErrInsecurePrefix,
+	ErrPayTypeNotEnabled,
+	ErrInvalidSignature,
+	ErrInvalidCRC,
+	ErrPaymentLinkExpired,
+	ErrDeepLinkUnsupported,
+	ErrOrderNotFound,
+}
+
+// ClassifyError returns the kind of err, so that callers can branch on it
+// without switching on the concrete error types:
+//
+//	switch qfpayslim.ClassifyError(err) {
+//	case qfpayslim.KindBusiness:
+//		// show the error to the customer
+//	case qfpayslim.KindTransport:
+//		// query the order before trying again
+//	}
+//
+// Errors of invalid input or of a response that contradicts it, including
+// numbers and times that cannot be parsed, are KindValidation. ErrPaymentTimeout
+// is KindCanceled like an expired context. Any other error is KindTransport.
+func ClassifyError(err error) ErrorKind {
+	var qfErr QFError
+	var validationErr ValidationError
+	var mismatchErr MismatchError
+	var paginationErr PaginationError
+	var numErr *strconv.NumError
+	var timeErr *time.ParseError
+	switch {
+	case err == nil:
+		return KindNone
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, ErrPaymentTimeout):
+		return KindCanceled
+	case errors.As(err, &qfErr):
+		return KindBusiness
+	case errors.As(err, &validationErr), errors.As(err, &mismatchErr),
+		errors.As(err, &paginationErr), errors.As(err, &numErr), errors.As(err, &timeErr):
+		return KindValidation
+	}
+	for _, target := range validationErrors {
+		if errors.Is(err, target) {
+			return KindValidation
+		}
+	}
+	return KindTransport
+}
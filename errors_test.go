@@ -0,0 +1,73 @@
+package qfpayslim
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	_, numErr := strconv.Atoi("1.5")
+	_, timeErr := time.Parse(DefaultTimeFormat, "yesterday")
+	tests := []struct {
+		name string
+		err  error
+		want ErrorKind
+	}{
+		{"nil", nil, KindNone},
+		{"transport", errTest, KindTransport},
+		{"HTML response", HTMLResponseError{StatusCode: 502}, KindTransport},
+		{"QFError", QFError{Code: "1108"}, KindBusiness},
+		{"wrapped QFError", fmt.Errorf("payment: %w", QFError{Code: "1108"}), KindBusiness},
+		{"ValidationError", ValidationError{"bad"}, KindValidation},
+		{"MismatchError", MismatchError{"out_trade_no", "A1", "B1"}, KindValidation},
+		{"PaginationError", PaginationError{}, KindValidation},
+		{"number", numErr, KindValidation},
+		{"time", timeErr, KindValidation},
+		{"canceled", context.Canceled, KindCanceled},
+		{"deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), KindCanceled},
+		{"payment timeout", ErrPaymentTimeout, KindCanceled},
+	}
+	for _, err := range validationErrors {
+		tests = append(tests, struct {
+			name string
+			err  error
+			want ErrorKind
+		}{err.Error(), fmt.Errorf("wrapped: %w", err), KindValidation})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyError(tt.err); got != tt.want {
+				t.Errorf("ClassifyError(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyErrorResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    ErrorKind
+	}{
+		{"success", respondJSON(`{"respcd":"0000"}`), KindNone},
+		{"business", respondJSON(`{"respcd":"1108","resperr":"Invalid parameters"}`), KindBusiness},
+		{"HTML page", respond("text/html", "<html></html>"), KindTransport},
+		{"connection closed", func(w http.ResponseWriter, r *http.Request) {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}, KindTransport},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, tt.handler)
+			err := c.PostForm(context.Background(), "/trade/v1/payment", nil)
+			if got := ClassifyError(err); got != tt.want {
+				t.Errorf("ClassifyError(%v) = %s, want %s", err, got, tt.want)
+			}
+		})
+	}
+}
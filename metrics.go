@@ -0,0 +1,34 @@
+package qfpayslim
+
+import "time"
+
+// MetricsRecorder receives measurements of every request sent by Do,
+// including retries. It can be backed by Prometheus or any other metrics
+// library without this package depending on it.
+type MetricsRecorder interface {
+	// ObserveRequest is called after each attempt with the path of the
+	// endpoint, the HTTP status code (0 if no response was received) and
+	// the duration of the round trip.
+	ObserveRequest(endpoint string, status int, dur time.Duration)
+
+	// IncError is called after each failed attempt with the path of the
+	// endpoint and the QFError code, or "transport" or "html" for errors
+	// that did not come from QFPay.
+	IncError(endpoint, code string)
+}
+
+func (c *Client) record(endpoint string, status int, dur time.Duration, err error) {
+	if c.Metrics == nil {
+		return
+	}
+	c.Metrics.ObserveRequest(endpoint, status, dur)
+	switch e := err.(type) {
+	case nil:
+	case QFError:
+		c.Metrics.IncError(endpoint, e.Code)
+	case HTMLResponseError:
+		c.Metrics.IncError(endpoint, "html")
+	default:
+		c.Metrics.IncError(endpoint, "transport")
+	}
+}
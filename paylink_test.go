@@ -0,0 +1,88 @@
+package qfpayslim
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPaymentLink(t *testing.T) {
+	c := &Client{Key: testKey}
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	link := PaymentLink{OutTradeNo: "INV-1", Cents: 1000, Currency: "JPY", Expires: expires}
+	raw, err := c.PaymentLinkURL("https://shop.example/pay?ref=mail&ref=wa&sign=merchant", link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Query().Get("txamt"); got != "10" {
+		t.Errorf("txamt = %s, want 10 yen", got)
+	}
+	got, err := c.VerifyPaymentLink(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.OutTradeNo != link.OutTradeNo || got.Cents != link.Cents || got.Currency != link.Currency || !got.Expires.Equal(expires) {
+		t.Errorf("VerifyPaymentLink = %+v, want %+v", *got, link)
+	}
+
+	rotated := &Client{Key: "NEWKEY", SecondaryKey: testKey}
+	if _, err := rotated.VerifyPaymentLink(u); err != nil {
+		t.Errorf("link signed with SecondaryKey rejected: %v", err)
+	}
+	if _, err := (&Client{Key: "OTHERKEY"}).VerifyPaymentLink(u); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("wrong key: err = %v, want ErrInvalidSignature", err)
+	}
+
+	tampered := map[string]func(url.Values){
+		"amount":          func(v url.Values) { v.Set("txamt", "1") },
+		"currency":        func(v url.Values) { v.Set("txcurrcd", "HKD") },
+		"expiry":          func(v url.Values) { v.Set("expires", "99999999999") },
+		"order number":    func(v url.Values) { v.Set("out_trade_no", "INV-2") },
+		"base parameter":  func(v url.Values) { v.Set("sign", "other") },
+		"repeated value":  func(v url.Values) { v["ref"] = []string{"mail", "sms"} },
+		"added parameter": func(v url.Values) { v.Set("coupon", "FREE") },
+		"missing token":   func(v url.Values) { v.Del("token") },
+	}
+	for name, tamper := range tampered {
+		t.Run(name, func(t *testing.T) {
+			values := u.Query()
+			tamper(values)
+			tu := *u
+			tu.RawQuery = values.Encode()
+			if _, err := c.VerifyPaymentLink(&tu); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("err = %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+}
+
+func TestPaymentLinkBoundaries(t *testing.T) {
+	// Without escaping, both would be signed as a=1&b=2.
+	c := &Client{Key: testKey}
+	one := url.Values{"a": {"1&b=2"}}
+	two := url.Values{"a": {"1"}, "b": {"2"}}
+	if paymentLinkToken(one, c.Key) == paymentLinkToken(two, c.Key) {
+		t.Error("different parameters have the same token")
+	}
+}
+
+func TestPaymentLinkExpired(t *testing.T) {
+	c := &Client{Key: testKey}
+	raw, err := c.PaymentLinkURL("https://shop.example/pay", PaymentLink{OutTradeNo: "INV-1", Cents: 1000, Expires: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(raw)
+	if _, err := c.VerifyPaymentLink(u); !errors.Is(err, ErrPaymentLinkExpired) {
+		t.Errorf("err = %v, want ErrPaymentLinkExpired", err)
+	}
+	if !strings.Contains(raw, "txcurrcd=HKD") {
+		t.Errorf("link %s does not default to HKD", raw)
+	}
+}
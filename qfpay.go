@@ -6,7 +6,10 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -26,8 +29,17 @@ const (
 	PayTypeWechatPayQRCode = "800201" // WeChat Merchant Presented QR Code Payment (MPM) (Overseas & HK Merchants)
 	PayTypePayMeQRCode     = "805801" // PayMe Merchant Presented QR Code Payment in store (MPM) (HK Merchants)
 	PayTypeFPSQRCode       = "802001" // FPS Merchant Presented QR Code Payment (MPM) (HK Merchants)
+	PayTypeUnionPayQRCode  = "800701" // UnionPay QuickPass Merchant Presented QR Code Payment (MPM)
 	PayTypeAlipayAPP       = "801510" // Alipay In-App Payment (HK Merchants)
 	PayTypeAlipayWAP       = "801512" // Alipay Online WAP Payment (HK Merchants)
+	PayTypeWechatPayAPP    = "800210" // WeChat In-App Payment (Overseas & HK Merchants)
+	PayTypeWechatPayJSAPI  = "800207" // WeChat JSAPI Payment in the WeChat browser (Official Account)
+	PayTypeWechatPayMini   = "800213" // WeChat Mini Program Payment (HK Merchants)
+
+	PayTypeAlipayCPM    = "800108" // Alipay Consumer Presented QR Code Payment (CPM) (Overseas & HK Merchants)
+	PayTypeWechatPayCPM = "800208" // WeChat Consumer Presented QR Code Payment (CPM) (Overseas & HK Merchants)
+	PayTypePayMeCPM     = "805808" // PayMe Consumer Presented QR Code Payment (CPM) (HK Merchants)
+	PayTypeUnionPayCPM  = "800708" // UnionPay QuickPass Consumer Presented QR Code Payment (CPM)
 )
 
 // Client struct is used to interact with QFPay API.
@@ -36,18 +48,129 @@ type Client struct {
 	AppCode string // 32-character string
 	Key     string // 32-character string
 	Debug   bool   // show request and response body
+
+	// SecondaryKey is also accepted when verifying callbacks, so that keys
+	// can be rotated without downtime: set the new key as SecondaryKey,
+	// have QFPay switch to it, then move it to Key and clear SecondaryKey.
+	// Outgoing requests are always signed with Key.
+	SecondaryKey string
+
+	HTTPClient       *http.Client    // defaults to http.DefaultClient, see DefaultTransport
+	AllowInsecure    bool            // allow a Prefix that is not https://, e.g. for a local mock server
+	Location         *time.Location  // time zone of txdtm, defaults to Hong Kong time (UTC+8)
+	TimeFormat       string          // layout of txdtm, defaults to DefaultTimeFormat
+	StrictValidation bool            // check that responses echo the out_trade_no that was sent
+	Retry            *RetryPolicy    // retry failed requests that are safe to repeat, nil disables retries
+	Metrics          MetricsRecorder // observe requests and errors, may be nil
+	KeyPosition      KeyPosition     // where the key is placed when signing, defaults to KeySuffix
+	StrictJSON       bool            // reject unknown fields when decoding responses, to catch schema drift
+
+	// RetryableCodes lists the QFError codes retried by Retry, which only
+	// retries transport errors otherwise. Transient codes include 1143 and
+	// 1145 (transaction in progress) and 1297 (banking system busy). It is
+	// empty by default so that no business error is retried. Do not add
+	// 1298, which QFPay asks not to repeat.
+	RetryableCodes []string
+
+	// EnabledPayTypes lists the pay types enabled for the merchant. QFPay
+	// has no API to look them up, so they have to be configured. If set,
+	// MakePayment rejects other pay types with ErrPayTypeNotEnabled.
+	EnabledPayTypes []string
+
+	// PaidCodes lists the respcd values that IsPaid treats as paid. It
+	// defaults to 0000 only; see QueryResponse.PaidWith.
+	PaidCodes []string
+
+	// SignExclude lists further keys left out when signing, in addition
+	// to DefaultSignExclude which are always left out.
+	SignExclude []string
+
+	// RefundWindows limits how long after the payment Refund and
+	// RefundPayment accept a refund, by pay type. Wallets differ here, PayMe
+	// in particular, and the limits depend on the merchant agreement, so
+	// they have to be configured. Pay types not listed are not limited.
+	RefundWindows map[string]time.Duration
+}
+
+// Clone returns a copy of the client that can be changed without affecting
+// the original, e.g. to enable Debug for a single call. Retry, the slices
+// and RefundWindows are copied; HTTPClient is shared with the original so that
+// connections are reused, and Location is shared as it is immutable.
+func (c *Client) Clone() *Client {
+	clone := *c
+	if c.Retry != nil {
+		retry := *c.Retry
+		clone.Retry = &retry
+	}
+	clone.RetryableCodes = append([]string(nil), c.RetryableCodes...)
+	clone.PaidCodes = append([]string(nil), c.PaidCodes...)
+	clone.EnabledPayTypes = append([]string(nil), c.EnabledPayTypes...)
+	clone.SignExclude = append([]string(nil), c.SignExclude...)
+	if c.RefundWindows != nil {
+		clone.RefundWindows = make(map[string]time.Duration, len(c.RefundWindows))
+		for k, v := range c.RefundWindows {
+			clone.RefundWindows[k] = v
+		}
+	}
+	return &clone
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// String returns the prefix and the masked credentials of the client so that
+// logging a client does not leak its secrets.
+func (c Client) String() string {
+	return "qfpayslim.Client{Prefix: " + c.Prefix + ", AppCode: " + mask(c.AppCode) + ", Key: " + mask(c.Key) + "}"
+}
+
+// mask hides all but the first and last 4 characters of a secret, or the
+// whole secret if it is too short.
+func mask(secret string) string {
+	if len(secret) < 16 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:4] + strings.Repeat("*", len(secret)-8) + secret[len(secret)-4:]
 }
 
+// DefaultTimeFormat is the layout of txdtm expected by QFPay.
+const DefaultTimeFormat = "2006-01-02 15:04:05"
+
+var hongKong = time.FixedZone("HKT", 8*60*60)
+
 type Request struct {
 	*http.Request
-	client *Client
+	client    *Client
+	attempts  int
+	requestID string
+
+	response     *http.Response // last response, its body is in responseBody
+	responseBody []byte
+
+	outTradeNo string // expected echo of out_trade_no, checked if StrictValidation is set
+}
+
+// RequestID returns the request ID of the last response, if QFPay sent one.
+func (req *Request) RequestID() string {
+	return req.requestID
+}
+
+// Attempts returns the number of times the request has been sent, including
+// retries.
+func (req *Request) Attempts() int {
+	return req.attempts
 }
 
 // QFError represents an API error response from QFPay.
 type QFError struct {
-	Code     string `json:"respcd"`
-	Err      string `json:"resperr"`
-	Messsage string `json:"respmsg"`
+	Code      string `json:"respcd"`
+	Err       string `json:"resperr"`
+	Messsage  string `json:"respmsg"`
+	RequestID string `json:"-"` // request ID from the response headers, quote it to QFPay support
 }
 
 func (e QFError) Error() string {
@@ -55,13 +178,92 @@ func (e QFError) Error() string {
 	if e.Messsage != "" {
 		err = err + " (" + e.Messsage + ")"
 	}
+	if e.RequestID != "" {
+		err = err + ", RequestID=" + e.RequestID
+	}
 	return "Error: Code=" + e.Code + ", Message=" + err
 }
 
+// MarshalJSON encodes the error as {"code":...,"error":...,"message":...},
+// plus "request_id" if known, so that tools built on the client can emit
+// errors in a stable shape.
+func (e QFError) MarshalJSON() ([]byte, error) {
+	v := map[string]string{
+		"code":    e.Code,
+		"error":   e.Err,
+		"message": e.Messsage,
+	}
+	if e.RequestID != "" {
+		v["request_id"] = e.RequestID
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes both the shape produced by MarshalJSON and the
+// respcd/resperr/respmsg fields of QFPay responses.
+func (e *QFError) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Code      string `json:"code"`
+		Err       string `json:"error"`
+		Messsage  string `json:"message"`
+		RequestID string `json:"request_id"`
+		Respcd    string `json:"respcd"`
+		Resperr   string `json:"resperr"`
+		Respmsg   string `json:"respmsg"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Respcd != "" || v.Resperr != "" || v.Respmsg != "" {
+		*e = QFError{v.Respcd, v.Resperr, v.Respmsg, ""}
+	} else {
+		*e = QFError{v.Code, v.Err, v.Messsage, v.RequestID}
+	}
+	return nil
+}
+
+// ErrPayTypeNotEnabled is returned by MakePayment if the pay type is not
+// listed in EnabledPayTypes.
+var ErrPayTypeNotEnabled = errors.New("qfpayslim: pay type is not enabled")
+
+// ErrInsecurePrefix is returned by NewRequest if Prefix is not an HTTPS URL
+// and AllowInsecure is not set.
+var ErrInsecurePrefix = errors.New("qfpayslim: prefix is not https://")
+
+// HTMLResponseError is returned when the server responds with an HTML page
+// instead of JSON, usually a challenge or error page from a CDN or WAF in
+// front of the API.
+type HTMLResponseError struct {
+	StatusCode int
+	Snippet    string // beginning of the response body
+}
+
+func (e HTMLResponseError) Error() string {
+	return "qfpayslim: unexpected HTML response (Status=" + strconv.Itoa(e.StatusCode) + "): " + e.Snippet
+}
+
+// MismatchError is returned if StrictValidation is set and a field echoed by
+// QFPay does not match the value that was sent.
+type MismatchError struct {
+	Field    string
+	Expected string
+	Got      string
+}
+
+func (e MismatchError) Error() string {
+	return "qfpayslim: " + e.Field + " mismatch, expected " + e.Expected + ", got " + e.Got
+}
+
 // NewRequest creates a new HTTP request with context, method, URL, and body.
-// If the request body is already an `io.Reader`, it is used as-is. Otherwise,
-// the request body is serialized into JSON format.
+// If the request body is already an `io.Reader`, it is read into memory unless
+// it is a *bytes.Buffer, *bytes.Reader or *strings.Reader, so that the body can
+// be replayed on retries and redirects. Otherwise, the request body is
+// serialized into JSON format. ErrInsecurePrefix is
+// returned if Prefix is not HTTPS, unless AllowInsecure is set.
 func (c *Client) NewRequest(ctx context.Context, method, url string, reqBody interface{}) (*Request, error) {
+	if !c.AllowInsecure && !strings.HasPrefix(c.Prefix, "https://") {
+		return nil, ErrInsecurePrefix
+	}
 	r, err := reqBodyToReader(reqBody)
 	if err != nil {
 		return nil, err
@@ -71,53 +273,468 @@ func (c *Client) NewRequest(ctx context.Context, method, url string, reqBody int
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	return &Request{req, c}, nil
+	return &Request{Request: req, client: c}, nil
 }
 
-// MakePayment creates a payment request to the QFPay API.
-// It accepts payment type, transaction number, item name, and amount in cents.
-func (c *Client) MakePayment(ctx context.Context, payType, outTradeNo, goodsName string, cents int, extra map[string]string) (*Request, error) {
+// NewFormRequest creates a signed POST request with the payload sent as a
+// form-encoded body.
+func (c *Client) NewFormRequest(ctx context.Context, path string, payload url.Values) (*Request, error) {
+	return c.NewSignedRequest(ctx, "POST", path, payload)
+}
+
+// NewSignedRequest creates a request signed with GenerateSign. The payload
+// is sent as the query string for methods without a body (GET, HEAD and
+// DELETE) and as a form-encoded body otherwise; the signature covers the
+// same values either way, so callers never have to pick the representation
+// to sign:
+//
+//	payload := url.Values{}
+//	payload.Set("out_trade_no", "TradeNo")
+//	req, err := qfpay.NewSignedRequest(ctx, "GET", "/trade/v1/query", payload)
+func (c *Client) NewSignedRequest(ctx context.Context, method, path string, payload url.Values) (*Request, error) {
+	return c.newSignedRequest(ctx, method, path, payload, c.GenerateSign(payload))
+}
+
+// newSignedRequest is NewSignedRequest with a precomputed signature.
+func (c *Client) newSignedRequest(ctx context.Context, method, path string, payload url.Values, sign string) (*Request, error) {
+	var req *Request
+	var err error
+	switch method {
+	case "GET", "HEAD", "DELETE":
+		if len(payload) > 0 {
+			path += "?" + payload.Encode()
+		}
+		req, err = c.NewRequest(ctx, method, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Del("Content-Type")
+	default:
+		req, err = c.NewRequest(ctx, method, path, strings.NewReader(payload.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("X-QF-APPCODE", c.AppCode)
+	req.Header.Set("X-QF-SIGN", sign)
+	req.Header.Set("X-QF-SIGNTYPE", "MD5")
+	return req, nil
+}
+
+// Call signs and sends the values to any endpoint with the given method,
+// then parses the response into dest the same way as Do. The values are sent
+// as the query string for GET, HEAD and DELETE and as a form-encoded body
+// otherwise. It is useful for endpoints that do not have a dedicated method
+// yet.
+func (c *Client) Call(ctx context.Context, method, path string, values url.Values, dest ...interface{}) error {
+	req, err := c.NewSignedRequest(ctx, method, path, values)
+	if err != nil {
+		return err
+	}
+	return req.Do(dest...)
+}
+
+// PostForm is like Call with the POST method.
+func (c *Client) PostForm(ctx context.Context, path string, values url.Values, dest ...interface{}) error {
+	return c.Call(ctx, "POST", path, values, dest...)
+}
+
+// BuildPaymentForm returns the form values of a payment request and their
+// signature without sending anything. It is useful for proxies and audit logs.
+// Like MakePayment, cents is converted to the minor unit of the currency, and
+// an error is returned for amounts the currency cannot represent.
+func (c *Client) BuildPaymentForm(payType, outTradeNo, goodsName string, cents int, extra map[string]string) (url.Values, string, error) {
+	currency := paymentCurrency(extra)
+	amount, err := minorAmount(cents, currency)
+	if err != nil {
+		return nil, "", err
+	}
 	payload := url.Values{}
-	payload.Set("txamt", strconv.Itoa(cents))
-	payload.Set("txcurrcd", "HKD")
+	payload.Set("txamt", strconv.Itoa(amount))
+	payload.Set("txcurrcd", currency)
 	payload.Set("pay_type", payType)
 	payload.Set("out_trade_no", outTradeNo)
 	payload.Set("goods_name", goodsName)
-	payload.Set("txdtm", time.Now().UTC().Format("2006-01-02 15:04:05"))
+	payload.Set("txdtm", c.txdtm(time.Now()))
 	for k, v := range extra {
 		payload.Set(k, v)
 	}
-	req, err := c.NewRequest(ctx, "POST", "/trade/v1/payment", strings.NewReader(payload.Encode()))
+	return payload, c.GenerateSign(payload), nil
+}
+
+// paymentCurrency returns the txcurrcd of extra, or HKD.
+func paymentCurrency(extra map[string]string) string {
+	if currency := extra["txcurrcd"]; currency != "" {
+		return currency
+	}
+	return "HKD"
+}
+
+// PayTypeEnabled reports whether payType is listed in EnabledPayTypes, or
+// true if EnabledPayTypes is empty.
+func (c *Client) PayTypeEnabled(payType string) bool {
+	return len(c.EnabledPayTypes) == 0 || contains(c.EnabledPayTypes, payType)
+}
+
+// MaxAttachLength is the maximum length in bytes of the attach field.
+const MaxAttachLength = 127
+
+// MakePayment creates a payment request to the QFPay API.
+// It accepts payment type, transaction number, item name, and amount in cents.
+//
+// The currency is HKD unless "txcurrcd" is set in extra. The amount is always
+// given in cents, 1/100 of a unit, and converted to the minor unit of the
+// currency, so 1000 cents of JPY are sent as 10 yen; amounts that are not
+// whole in the currency are rejected.
+//
+// Extra fields are signed and sent along. For example, "attach" holds up to
+// MaxAttachLength bytes of merchant data that is returned unchanged in the
+// callback and query responses (QueryResponse.Attach).
+func (c *Client) MakePayment(ctx context.Context, payType, outTradeNo, goodsName string, cents int, extra map[string]string) (*Request, error) {
+	if !c.PayTypeEnabled(payType) {
+		return nil, ErrPayTypeNotEnabled
+	}
+	if len(extra["attach"]) > MaxAttachLength {
+		return nil, validationErrorf("attach is longer than %d bytes", MaxAttachLength)
+	}
+	payload, sign, err := c.BuildPaymentForm(payType, outTradeNo, goodsName, cents, extra)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("X-QF-APPCODE", c.AppCode)
-	req.Header.Set("X-QF-SIGN", c.GenerateSign(payload))
-	req.Header.Set("X-QF-SIGNTYPE", "MD5")
+	req, err := c.newSignedRequest(ctx, "POST", "/trade/v1/payment", payload, sign)
+	if err != nil {
+		return nil, err
+	}
+	req.outTradeNo = payload.Get("out_trade_no")
 	return req, nil
 }
 
-// CloseSyssn creates a close order request by syssn.
+// PaymentResponse holds the fields of the response of a payment.
+type PaymentResponse struct {
+	Cardcd     string          `json:"cardcd"`       // Card number
+	Chnlsn     string          `json:"chnlsn"`       // Wallet/Channel transaction number
+	OutTradeNo string          `json:"out_trade_no"` // API order number
+	PayParams  json.RawMessage `json:"pay_params"`   // Parameters for the wallet SDK of in-app and JSAPI payments
+	PayType    string          `json:"pay_type"`     // Payment type
+	PayURL     string          `json:"pay_url"`      // URL to redirect the browser to for online WAP payments
+	Paydtm     string          `json:"paydtm"`       // Payment time of the transaction
+	QRCode     string          `json:"qrcode"`       // QR code content for MPM payments
+	Respcd     string          `json:"respcd"`       // Response code
+	Resperr    string          `json:"resperr"`      // Response message
+	Respmsg    string          `json:"respmsg"`      // Additional response message
+	Sysdtm     string          `json:"sysdtm"`       // System transaction time
+	Syssn      string          `json:"syssn"`        // QFPay transaction number
+	Txamt      string          `json:"txamt"`        // Transaction amount in the minor unit of the currency
+	Txcurrcd   string          `json:"txcurrcd"`     // Transaction currency
+	Txdtm      string          `json:"txdtm"`        // Request transaction time
+	Udid       string          `json:"udid"`         // Unique transaction device ID
+}
+
+// cpmPayTypes lists the pay types accepted by MakeCPMPayment.
+var cpmPayTypes = []string{PayTypeAlipayCPM, PayTypeWechatPayCPM, PayTypePayMeCPM, PayTypeUnionPayCPM}
+
+// MakeCPMPayment creates a consumer presented mode payment, charging the
+// customer by the barcode or QR code (auth_code) scanned from their wallet
+// app. The pay type must be one of the CPM pay types.
+//
+// The payment may still be waiting for the customer to confirm it in the
+// app when the request returns, so check the result with Query or
+// WaitForPayment before handing over the goods.
+func (c *Client) MakeCPMPayment(ctx context.Context, payType, authCode, outTradeNo, goodsName string, cents int, extra map[string]string) (*Request, error) {
+	if !contains(cpmPayTypes, payType) {
+		return nil, validationErrorf("pay type %s is not a CPM pay type", payType)
+	}
+	if authCode == "" {
+		return nil, ValidationError{"auth_code is required for CPM payments"}
+	}
+	extra = mergeExtra(extra, map[string]string{"auth_code": authCode})
+	return c.MakePayment(ctx, payType, outTradeNo, goodsName, cents, extra)
+}
+
+// LineItem is an item of a payment, sent in goods_detail.
+type LineItem struct {
+	Name           string `json:"goods_name"`
+	Quantity       int    `json:"quantity"`
+	UnitPriceCents int    `json:"price"`
+}
+
+// MakeItemizedPayment is like MakePayment but also sends the items in
+// goods_detail as a JSON array, with the prices converted to the minor unit
+// of the currency like the amount. An error is returned if the items do not
+// add up to cents.
+func (c *Client) MakeItemizedPayment(ctx context.Context, payType, outTradeNo, goodsName string, cents int, items []LineItem, extra map[string]string) (*Request, error) {
+	total := 0
+	for _, item := range items {
+		total += item.Quantity * item.UnitPriceCents
+	}
+	if total != cents {
+		return nil, validationErrorf("items add up to %d, expected %d", total, cents)
+	}
+	converted := make([]LineItem, len(items))
+	for i, item := range items {
+		price, err := minorAmount(item.UnitPriceCents, paymentCurrency(extra))
+		if err != nil {
+			return nil, err
+		}
+		converted[i] = item
+		converted[i].UnitPriceCents = price
+	}
+	detail, err := json.Marshal(converted)
+	if err != nil {
+		return nil, err
+	}
+	extra = mergeExtra(extra, map[string]string{"goods_detail": string(detail)})
+	return c.MakePayment(ctx, payType, outTradeNo, goodsName, cents, extra)
+}
+
+// WechatAppPayment holds the parameters passed to the WeChat APP SDK to
+// invoke the payment on the mobile device.
+type WechatAppPayment struct {
+	AppID     string      `json:"appid"`
+	PartnerID string      `json:"partnerid"`
+	PrepayID  string      `json:"prepayid"`
+	Package   string      `json:"package"`
+	NonceStr  string      `json:"noncestr"`
+	Timestamp json.Number `json:"timestamp"`
+	Sign      string      `json:"sign"`
+}
+
+// MakeWechatAppPayment creates a WeChat in-app payment and returns the
+// parameters for the WeChat APP SDK.
+func (c *Client) MakeWechatAppPayment(ctx context.Context, outTradeNo, goodsName string, cents int, extra map[string]string) (*WechatAppPayment, error) {
+	req, err := c.MakePayment(ctx, PayTypeWechatPayAPP, outTradeNo, goodsName, cents, extra)
+	if err != nil {
+		return nil, err
+	}
+	var params WechatAppPayment
+	if err := req.Do(&params, "pay_params"); err != nil {
+		return nil, err
+	}
+	if params.PrepayID == "" {
+		return nil, errors.New("qfpayslim: no pay_params in response")
+	}
+	return &params, nil
+}
+
+// AlipayAppPayment holds the order string passed to the Alipay mobile SDK,
+// e.g. to payV2 of AlipaySDK on iOS or PayTask on Android.
+type AlipayAppPayment struct {
+	OrderString string
+}
+
+// UnmarshalJSON accepts pay_params either as the order string or as an
+// object of the order parameters, which are then encoded in the form the
+// SDK expects.
+func (p *AlipayAppPayment) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &p.OrderString); err == nil {
+		return nil
+	}
+	var params map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&params); err != nil {
+		return err
+	}
+	values := url.Values{}
+	for k, v := range params {
+		values.Set(k, fmt.Sprint(v))
+	}
+	p.OrderString = values.Encode()
+	return nil
+}
+
+// MakeAlipayAppPayment creates an Alipay in-app payment and returns the order
+// string for the Alipay mobile SDK.
+func (c *Client) MakeAlipayAppPayment(ctx context.Context, outTradeNo, goodsName string, cents int, extra map[string]string) (*AlipayAppPayment, error) {
+	req, err := c.MakePayment(ctx, PayTypeAlipayAPP, outTradeNo, goodsName, cents, extra)
+	if err != nil {
+		return nil, err
+	}
+	var params AlipayAppPayment
+	if err := req.Do(&params, "pay_params"); err != nil {
+		return nil, err
+	}
+	if params.OrderString == "" {
+		return nil, errors.New("qfpayslim: no pay_params in response")
+	}
+	return &params, nil
+}
+
+// WechatJSAPIPayment holds the parameters passed to WeixinJSBridge to invoke
+// the payment in the WeChat browser. The JSON keys match what
+// WeixinJSBridge.invoke("getBrandWCPayRequest", ...) expects.
+type WechatJSAPIPayment struct {
+	AppID     string      `json:"appId"`
+	TimeStamp json.Number `json:"timeStamp"`
+	NonceStr  string      `json:"nonceStr"`
+	Package   string      `json:"package"`
+	SignType  string      `json:"signType"`
+	PaySign   string      `json:"paySign"`
+}
+
+// MakeWechatJSAPIPayment creates a WeChat Official Account (JSAPI) payment
+// for the user with the given openid of the account, and returns the
+// parameters for WeixinJSBridge.
+func (c *Client) MakeWechatJSAPIPayment(ctx context.Context, openID, outTradeNo, goodsName string, cents int, extra map[string]string) (*WechatJSAPIPayment, error) {
+	if openID == "" {
+		return nil, ValidationError{"openid is required for JSAPI payments"}
+	}
+	extra = mergeExtra(extra, map[string]string{"sub_openid": openID})
+	req, err := c.MakePayment(ctx, PayTypeWechatPayJSAPI, outTradeNo, goodsName, cents, extra)
+	if err != nil {
+		return nil, err
+	}
+	var params WechatJSAPIPayment
+	if err := req.Do(&params, "pay_params"); err != nil {
+		return nil, err
+	}
+	if params.Package == "" {
+		return nil, errors.New("qfpayslim: no pay_params in response")
+	}
+	return &params, nil
+}
+
+// MakeWechatMiniProgramPayment creates a WeChat Mini Program payment for the
+// user with the given openid of the mini program, as returned by
+// jscode2session for the code from wx.login, and returns the parameters for
+// wx.requestPayment. AppID is not needed by wx.requestPayment.
+func (c *Client) MakeWechatMiniProgramPayment(ctx context.Context, openID, outTradeNo, goodsName string, cents int, extra map[string]string) (*WechatJSAPIPayment, error) {
+	if openID == "" {
+		return nil, ValidationError{"openid is required for mini program payments"}
+	}
+	extra = mergeExtra(extra, map[string]string{"sub_openid": openID})
+	req, err := c.MakePayment(ctx, PayTypeWechatPayMini, outTradeNo, goodsName, cents, extra)
+	if err != nil {
+		return nil, err
+	}
+	var params WechatJSAPIPayment
+	if err := req.Do(&params, "pay_params"); err != nil {
+		return nil, err
+	}
+	if params.Package == "" {
+		return nil, errors.New("qfpayslim: no pay_params in response")
+	}
+	return &params, nil
+}
+
+// MakeWAPPayment creates an Alipay online WAP payment and returns its
+// response, with the URL to redirect the mobile browser to in PayURL. Set
+// "return_url" in extra to bring the customer back to the merchant site
+// after the payment.
+func (c *Client) MakeWAPPayment(ctx context.Context, outTradeNo, goodsName string, cents int, extra map[string]string) (*PaymentResponse, error) {
+	req, err := c.MakePayment(ctx, PayTypeAlipayWAP, outTradeNo, goodsName, cents, extra)
+	if err != nil {
+		return nil, err
+	}
+	var res PaymentResponse
+	if err := req.Do(&res); err != nil {
+		return nil, err
+	}
+	if res.PayURL == "" {
+		return nil, errors.New("qfpayslim: no pay_url in response")
+	}
+	return &res, nil
+}
+
+// CloseSyssn creates a close order request by syssn. The amount is in cents
+// of HKD, as the currency is not sent; use Close for other currencies.
 func (c *Client) CloseSyssn(ctx context.Context, syssn string, cents int) (*Request, error) {
+	amount, err := minorAmount(cents, "HKD")
+	if err != nil {
+		return nil, err
+	}
 	payload := url.Values{}
 	payload.Set("syssn", syssn)
-	payload.Set("txamt", strconv.Itoa(cents))
-	payload.Set("txdtm", time.Now().UTC().Format("2006-01-02 15:04:05"))
-	req, err := c.NewRequest(ctx, "POST", "/trade/v1/close", strings.NewReader(payload.Encode()))
+	payload.Set("txamt", strconv.Itoa(amount))
+	payload.Set("txdtm", c.txdtm(time.Now()))
+	return c.NewFormRequest(ctx, "/trade/v1/close", payload)
+}
+
+// CloseResponse holds the information returned from QFPay API for a close order request.
+type CloseResponse struct {
+	OutTradeNo string `json:"out_trade_no"` // API order number
+	Respcd     string `json:"respcd"`       // Close status
+	Resperr    string `json:"resperr"`      // Close status message
+	Respmsg    string `json:"respmsg"`      // Additional status message
+	Sysdtm     string `json:"sysdtm"`       // System transaction time
+	Syssn      string `json:"syssn"`        // QFPay transaction number
+	Txdtm      string `json:"txdtm"`        // Request transaction time
+}
+
+// Close closes the unpaid order of cents with the order number outTradeNo, so
+// that it can no longer be paid, e.g. for an abandoned MPM QR code. Like
+// CloseSyssn, it sends the amount of the order in txamt. As with MakePayment,
+// the currency is HKD unless "txcurrcd" is set in extra, and cents is
+// converted to its minor unit.
+func (c *Client) Close(ctx context.Context, outTradeNo string, cents int, extra map[string]string) (*CloseResponse, error) {
+	amount, err := minorAmount(cents, paymentCurrency(extra))
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("X-QF-APPCODE", c.AppCode)
-	req.Header.Set("X-QF-SIGN", c.GenerateSign(payload))
-	req.Header.Set("X-QF-SIGNTYPE", "MD5")
-	return req, nil
+	payload := url.Values{}
+	payload.Set("out_trade_no", outTradeNo)
+	payload.Set("txamt", strconv.Itoa(amount))
+	payload.Set("txdtm", c.txdtm(time.Now()))
+	for k, v := range extra {
+		payload.Set(k, v)
+	}
+	req, err := c.NewFormRequest(ctx, "/trade/v1/close", payload)
+	if err != nil {
+		return nil, err
+	}
+	var res CloseResponse
+	if err := req.Do(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// CancelResponse holds the information returned from QFPay API for a cancel request.
+type CancelResponse struct {
+	OrigSyssn  string `json:"orig_syssn"`   // QFPay transaction number of the cancelled payment
+	OutTradeNo string `json:"out_trade_no"` // API order number
+	Respcd     string `json:"respcd"`       // Cancel status
+	Resperr    string `json:"resperr"`      // Cancel status message
+	Respmsg    string `json:"respmsg"`      // Additional status message
+	Sysdtm     string `json:"sysdtm"`       // System transaction time
+	Syssn      string `json:"syssn"`        // QFPay transaction number of the cancellation
+	Txamt      string `json:"txamt"`        // Cancelled amount in the minor unit of the currency
+	Txdtm      string `json:"txdtm"`        // Request transaction time
+}
+
+// Cancel voids the payment of cents with the order number outTradeNo on the
+// day it was made, e.g. to reverse a POS payment instead of refunding it.
+// Payments, including unpaid ones, can only be cancelled on the same day.
+// As with MakePayment, the currency is HKD unless "txcurrcd" is set in extra,
+// and cents is converted to its minor unit.
+func (c *Client) Cancel(ctx context.Context, outTradeNo string, cents int, extra map[string]string) (*CancelResponse, error) {
+	amount, err := minorAmount(cents, paymentCurrency(extra))
+	if err != nil {
+		return nil, err
+	}
+	payload := url.Values{}
+	payload.Set("out_trade_no", outTradeNo)
+	payload.Set("txamt", strconv.Itoa(amount))
+	payload.Set("txdtm", c.txdtm(time.Now()))
+	for k, v := range extra {
+		payload.Set(k, v)
+	}
+	req, err := c.NewFormRequest(ctx, "/trade/v1/reversal", payload)
+	if err != nil {
+		return nil, err
+	}
+	var res CancelResponse
+	if err := req.Do(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
 }
 
 // QueryResponse holds the information returned from QFPay API for a payment request.
 // Fields included match the JSON response properties returned from the API.
 type QueryResponse struct {
+	Attach      string `json:"attach"`       // Merchant data sent with the payment
 	Cancel      string `json:"cancel"`       // Cancellation or refund indicator
 	Cardcd      string `json:"cardcd"`       // Card number
 	Cardtp      string `json:"cardtp"`       // Unknown
@@ -126,79 +743,447 @@ type QueryResponse struct {
 	Clisn       string `json:"clisn"`        // Unknown
 	Errmsg      string `json:"errmsg"`       // Payment status message
 	GoodsDetail string `json:"goods_detail"` // Product details
-	GoodsInfo   string `json:"goods_info"`   // Product description
+	GoodsInfo   string `json:"goods_info"`   // Product description, free-form text set by the merchant
 	GoodsName   string `json:"goods_name"`   // Product name
 	OrderType   string `json:"order_type"`   // Order type (payment / refund)
+	OrigSyssn   string `json:"orig_syssn"`   // QFPay transaction number of the refunded payment (refunds only)
 	OutTradeNo  string `json:"out_trade_no"` // API order number
 	PayType     string `json:"pay_type"`     // Payment type
 	Paydtm      string `json:"paydtm"`       // Payment time of the transaction
+	RefundAmt   string `json:"refund_amt"`   // Refunded amount in the minor unit of Txcurrcd, empty if not refunded
 	Respcd      string `json:"respcd"`       // Payment status
 	Sysdtm      string `json:"sysdtm"`       // System transaction time
 	Syssn       string `json:"syssn"`        // QFPay transaction number
-	Txamt       string `json:"txamt"`        // Transaction amount
+	Txamt       string `json:"txamt"`        // Transaction amount in the minor unit of Txcurrcd, see AmountCents
 	Txcurrcd    string `json:"txcurrcd"`     // Transaction currency
 	Txdtm       string `json:"txdtm"`        // Request transaction time
 	Udid        string `json:"udid"`         // Unique transaction device ID
 	Userid      string `json:"userid"`       // User ID
 }
 
+// queryResponseAliases maps alternative spellings of keys used by some
+// endpoint versions to the keys of QueryResponse.
+var queryResponseAliases = map[string]string{
+	"sys_sn":    "syssn",
+	"chnl_sn":   "chnlsn",
+	"chnl_sn2":  "chnlsn2",
+	"tx_amt":    "txamt",
+	"tx_currcd": "txcurrcd",
+	"tx_dtm":    "txdtm",
+	"pay_dtm":   "paydtm",
+	"sys_dtm":   "sysdtm",
+}
+
+// UnmarshalJSON decodes a QueryResponse, accepting JSON numbers as well as
+// strings for every field since some endpoints send txamt and the like as
+// numbers. Keys listed in queryResponseAliases are accepted as well; if both
+// spellings are present, the canonical key wins.
+func (res *QueryResponse) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for alias, key := range queryResponseAliases {
+		if v, ok := raw[alias]; ok {
+			if _, exists := raw[key]; !exists {
+				raw[key] = v
+			}
+			delete(raw, alias)
+		}
+	}
+	values := make(map[string]string, len(raw))
+	for k, v := range raw {
+		var str string
+		if err := json.Unmarshal(v, &str); err == nil {
+			values[k] = str
+			continue
+		}
+		var num json.Number
+		if err := json.Unmarshal(v, &num); err == nil {
+			values[k] = num.String()
+		}
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	type queryResponse QueryResponse
+	return json.Unmarshal(b, (*queryResponse)(res))
+}
+
+func (QueryResponse) fieldAliases() map[string]string {
+	return queryResponseAliases
+}
+
+// Paid reports whether the transaction succeeded, i.e. respcd is 0000.
 func (res QueryResponse) Paid() bool {
 	return res.Respcd == "0000"
 }
 
+// PaidWith reports whether respcd is one of codes, or 0000 if no codes are
+// given. Treating other codes as paid risks fulfilling orders that were never
+// charged, so only add codes QFPay documents as final success states.
+func (res QueryResponse) PaidWith(codes ...string) bool {
+	if len(codes) == 0 {
+		return res.Paid()
+	}
+	return contains(codes, res.Respcd)
+}
+
+// IsPaid reports whether the transaction is paid according to PaidCodes.
+func (c *Client) IsPaid(res QueryResponse) bool {
+	return res.PaidWith(c.PaidCodes...)
+}
+
+// AmountCents returns the transaction amount in cents, converted from the
+// minor unit of the currency in which txamt is given, so that 10 yen of JPY
+// are returned as 1000 cents like the amount passed to MakePayment.
+func (res QueryResponse) AmountCents() (int, error) {
+	return parseCents(res.Txamt, res.Txcurrcd)
+}
+
+// RefundedAmountCents returns the amount in cents that has been refunded,
+// or 0 if the transaction has not been refunded. Like AmountCents, it is
+// converted from the minor unit of the currency.
+func (res QueryResponse) RefundedAmountCents() (int, error) {
+	if res.RefundAmt == "" {
+		return 0, nil
+	}
+	return parseCents(res.RefundAmt, res.Txcurrcd)
+}
+
+// ChannelReferences returns the non-empty wallet/channel transaction numbers
+// of the transaction: Chnlsn is the number assigned by the wallet (e.g. the
+// WeChat Pay or Alipay transaction ID) and Chnlsn2 an additional number
+// added to the order. Quote them when escalating disputes to the wallet.
+func (res QueryResponse) ChannelReferences() []string {
+	var refs []string
+	for _, ref := range []string{res.Chnlsn, res.Chnlsn2} {
+		if ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// AssertPaid returns an error describing the first condition that fails:
+// the transaction is paid, its currency is currency and its amount, as
+// returned by AmountCents, is expectedCents.
+func (res QueryResponse) AssertPaid(expectedCents int, currency string) error {
+	if !res.Paid() {
+		return validationErrorf("order %s is not paid (respcd=%s, errmsg=%s)", res.OutTradeNo, res.Respcd, res.Errmsg)
+	}
+	if res.Txcurrcd != currency {
+		return validationErrorf("order %s currency is %s, expected %s", res.OutTradeNo, res.Txcurrcd, currency)
+	}
+	cents, err := res.AmountCents()
+	if err != nil {
+		return err
+	}
+	if cents != expectedCents {
+		return validationErrorf("order %s amount is %d cents, expected %d", res.OutTradeNo, cents, expectedCents)
+	}
+	return nil
+}
+
 // Query sends a request to inquire about past payment transactions.
 // Multiple transaction numbers can be queried in a single request by passing them as separate
-// arguments.
+// arguments. Duplicate numbers are sent only once.
 func (c *Client) Query(ctx context.Context, outTradeNo ...string) ([]QueryResponse, error) {
+	return c.QueryByType(ctx, "", outTradeNo...)
+}
+
+// Order types of QueryResponse.OrderType.
+const (
+	OrderTypePayment = "payment"
+	OrderTypeRefund  = "refund"
+)
+
+// QueryByType is like Query but only returns the records of orderType,
+// OrderTypePayment or OrderTypeRefund, for order numbers that have both
+// payment and refund records. An empty orderType returns all records.
+func (c *Client) QueryByType(ctx context.Context, orderType string, outTradeNo ...string) ([]QueryResponse, error) {
 	if len(outTradeNo) < 1 {
 		return nil, nil
 	}
 	payload := url.Values{}
-	payload.Set("out_trade_no", strings.Join(outTradeNo, ","))
-	req, err := c.NewRequest(ctx, "POST", "/trade/v1/query", strings.NewReader(payload.Encode()))
+	payload.Set("out_trade_no", strings.Join(dedup(outTradeNo), ","))
+	if orderType != "" {
+		payload.Set("order_type", orderType)
+	}
+	req, err := c.NewFormRequest(ctx, "/trade/v1/query", payload)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("X-QF-APPCODE", c.AppCode)
-	req.Header.Set("X-QF-SIGN", c.GenerateSign(payload))
-	req.Header.Set("X-QF-SIGNTYPE", "MD5")
 	var responses []QueryResponse
-	err = req.Do(&responses, "data.*")
-	return responses, err
+	if err := req.Do(&responses, "data.*"); err != nil {
+		return nil, err
+	}
+	if c.StrictValidation {
+		for _, res := range responses {
+			if !contains(outTradeNo, res.OutTradeNo) {
+				return responses, MismatchError{"out_trade_no", strings.Join(outTradeNo, ","), res.OutTradeNo}
+			}
+		}
+	}
+	if orderType == "" {
+		return responses, nil
+	}
+	filtered := responses[:0]
+	for _, res := range responses {
+		if res.OrderType == orderType {
+			filtered = append(filtered, res)
+		}
+	}
+	return filtered, nil
+}
+
+// QueryStatuses returns the payment status (respcd) of each order number
+// that QFPay knows about, keyed by order number.
+func (c *Client) QueryStatuses(ctx context.Context, outTradeNo ...string) (map[string]string, error) {
+	responses, err := c.Query(ctx, outTradeNo...)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]string, len(responses))
+	for _, res := range responses {
+		statuses[res.OutTradeNo] = res.Respcd
+	}
+	return statuses, nil
 }
 
 // QuerySyssn sends a request to inquire about past payment transactions by syssn.
+// Duplicate numbers are sent only once.
 func (c *Client) QuerySyssn(ctx context.Context, syssn ...string) ([]QueryResponse, error) {
 	if len(syssn) < 1 {
 		return nil, nil
 	}
 	payload := url.Values{}
-	payload.Set("syssn", strings.Join(syssn, ","))
-	req, err := c.NewRequest(ctx, "POST", "/trade/v1/query", strings.NewReader(payload.Encode()))
+	payload.Set("syssn", strings.Join(dedup(syssn), ","))
+	req, err := c.NewFormRequest(ctx, "/trade/v1/query", payload)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("X-QF-APPCODE", c.AppCode)
-	req.Header.Set("X-QF-SIGN", c.GenerateSign(payload))
-	req.Header.Set("X-QF-SIGNTYPE", "MD5")
 	var responses []QueryResponse
 	err = req.Do(&responses, "data.*")
 	return responses, err
 }
 
+// txdtm formats t as a QFPay transaction time in the client's location and
+// time format.
+func (c *Client) txdtm(t time.Time) string {
+	loc := c.Location
+	if loc == nil {
+		loc = hongKong
+	}
+	layout := c.TimeFormat
+	if layout == "" {
+		layout = DefaultTimeFormat
+	}
+	return t.In(loc).Format(layout)
+}
+
+// KeyPosition is where the key is placed in the signed content.
+type KeyPosition int
+
+const (
+	// KeySuffix appends the key to the content, which is what all
+	// documented QFPay open APIs expect.
+	KeySuffix KeyPosition = iota
+
+	// KeyPrefix prepends the key to the content, for endpoints that
+	// deviate from the documented rule.
+	KeyPrefix
+)
+
 // GenerateSign generates a signature for authenticating API requests.
+//
+// Keys listed in DefaultSignExclude or SignExclude are not signed, so that a
+// payload that already contains its signature (sign) or sign type (sign_type
+// or signtype) still gets the right signature.
 func (c *Client) GenerateSign(payload url.Values) string {
-	parts := make([]string, len(payload))
-	i := 0
+	return c.sign(signContent(payload, c.SignExclude))
+}
+
+// sign returns the MD5 signature of content with Key placed according to
+// KeyPosition.
+func (c *Client) sign(content string) string {
+	if c.KeyPosition == KeyPrefix {
+		return hash(c.Key+content, "MD5")
+	}
+	return hash(content+c.Key, "MD5")
+}
+
+// VerifySign reports whether expected is the signature of values signed with
+// key, using the MD5 or SHA256 sign type. Unlike the Client methods, it works
+// with any key, e.g. to verify stored callbacks of several merchants. Keys in
+// DefaultSignExclude are not signed. The comparison is done in constant time.
+func VerifySign(values url.Values, key, signType, expected string) bool {
+	signType = strings.ToUpper(signType)
+	if signType != "MD5" && signType != "SHA256" {
+		return false
+	}
+	actual := hash(signContent(values, nil)+key, signType)
+	return subtle.ConstantTimeCompare([]byte(actual), []byte(strings.ToUpper(expected))) == 1
+}
+
+// VerifyCallback reports whether sign, the X-QF-SIGN header of an
+// asynchronous notification, is the signature of the raw request body made
+// with Key or SecondaryKey. The comparison is done in constant time.
+func (c *Client) VerifyCallback(body []byte, sign string) bool {
+	expected := []byte(strings.ToUpper(sign))
+	for _, key := range []string{c.Key, c.SecondaryKey} {
+		if key == "" {
+			continue
+		}
+		actual := hash(string(body)+key, "MD5")
+		if subtle.ConstantTimeCompare([]byte(actual), expected) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyCallbackAmount is like VerifyCallback, but also checks that the
+// notification is for expectedCents in currency, so that a validly signed
+// notification of another order cannot be replayed. ErrInvalidSignature is
+// returned if the signature does not match, and a MismatchError if the
+// currency or amount does not; amounts are compared in cents, as returned
+// by QueryResponse.AmountCents.
+func (c *Client) VerifyCallbackAmount(body []byte, sign string, expectedCents int, currency string) (bool, error) {
+	if !c.VerifyCallback(body, sign) {
+		return false, ErrInvalidSignature
+	}
+	var res QueryResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return false, err
+	}
+	if res.Txcurrcd != currency {
+		return false, MismatchError{"txcurrcd", currency, res.Txcurrcd}
+	}
+	cents, err := res.AmountCents()
+	if err != nil {
+		return false, err
+	}
+	if cents != expectedCents {
+		return false, MismatchError{"txamt", strconv.Itoa(expectedCents), strconv.Itoa(cents)}
+	}
+	return true, nil
+}
+
+// ErrInvalidSignature is returned when a signature from QFPay does not match.
+var ErrInvalidSignature = errors.New("qfpayslim: invalid signature")
+
+// ParseReturnURL verifies the signed parameters QFPay appends to the return
+// URL the browser is redirected to after a WAP payment, and returns them
+// without the sign and sign_type parameters. ErrInvalidSignature is returned
+// if the signature does not match Key or SecondaryKey.
+func (c *Client) ParseReturnURL(u *url.URL) (url.Values, error) {
+	values := u.Query()
+	sign := values.Get("sign")
+	signType := values.Get("sign_type")
+	if signType == "" {
+		signType = "MD5"
+	}
+	values.Del("sign")
+	values.Del("sign_type")
+	if sign == "" {
+		return nil, ErrInvalidSignature
+	}
+	for _, key := range []string{c.Key, c.SecondaryKey} {
+		if key != "" && VerifySign(values, key, signType, sign) {
+			return values, nil
+		}
+	}
+	return nil, ErrInvalidSignature
+}
+
+// DefaultSignExclude lists the keys that are never signed: the signature
+// itself and the sign type.
+var DefaultSignExclude = []string{"sign", "sign_type", "signtype"}
+
+// signContent returns the payload as sorted key=value pairs joined by &,
+// leaving out the keys in DefaultSignExclude and exclude.
+func signContent(payload url.Values, exclude []string) string {
+	parts := make([]string, 0, len(payload))
 	for k := range payload {
-		parts[i] = k + "=" + payload.Get(k)
-		i += 1
+		if !contains(DefaultSignExclude, k) && !contains(exclude, k) {
+			parts = append(parts, k+"="+payload.Get(k))
+		}
 	}
 	sort.Strings(parts)
-	joined := strings.Join(parts, "&") + c.Key
-	return fmt.Sprintf("%X", md5.Sum([]byte(joined)))
+	return strings.Join(parts, "&")
+}
+
+// hash returns the uppercase hex digest of content using MD5 or SHA256.
+func hash(content, signType string) string {
+	if signType == "SHA256" {
+		return fmt.Sprintf("%X", sha256.Sum256([]byte(content)))
+	}
+	return fmt.Sprintf("%X", md5.Sum([]byte(content)))
+}
+
+// SignJSON generates a signature over the canonical JSON representation of
+// body, in which the keys of all objects are sorted and <, > and & are not
+// escaped. As in GenerateSign, the key is placed according to KeyPosition,
+// and top-level keys listed in DefaultSignExclude or SignExclude are not
+// signed.
+func (c *Client) SignJSON(body interface{}) (string, error) {
+	exclude := append(append([]string(nil), DefaultSignExclude...), c.SignExclude...)
+	b, err := canonicalJSON(body, exclude)
+	if err != nil {
+		return "", err
+	}
+	return c.sign(string(b)), nil
+}
+
+// NewJSONRequest creates a request for JSON endpoints, with body sent as its
+// canonical JSON representation and signed with SignJSON.
+func (c *Client) NewJSONRequest(ctx context.Context, method, path string, body interface{}) (*Request, error) {
+	b, err := canonicalJSON(body, nil)
+	if err != nil {
+		return nil, err
+	}
+	sign, err := c.SignJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.NewRequest(ctx, method, path, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-QF-APPCODE", c.AppCode)
+	req.Header.Set("X-QF-SIGN", sign)
+	req.Header.Set("X-QF-SIGNTYPE", "MD5")
+	return req, nil
+}
+
+// canonicalJSON returns body encoded as JSON with the keys of all objects
+// sorted and without HTML escaping, leaving out the top-level keys listed in
+// exclude.
+func canonicalJSON(body interface{}, exclude []string) ([]byte, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if obj, ok := v.(map[string]interface{}); ok {
+		for _, key := range exclude {
+			delete(obj, key)
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
 }
 
 // Do sends the HTTP request associated with the Request object.
@@ -209,50 +1194,70 @@ func (c *Client) GenerateSign(payload url.Values) string {
 //
 // If the 'dest' slice contains more than one value, they are expected to come in pairs with a
 // pointer
-// to a variable followed by the associated JSON key string.
+// to a variable followed by the associated JSON key string. Keys are dot-separated paths
+// where "*" matches every element of an array, so several parts of the response can be
+// extracted at once:
+//
+//	req.Do(&total, "data.total", &items, "data.list.*")
+//
+// An error is returned before sending the request if the pairs are malformed, and
+// after it if a key is not found in the response.
 //
 // If the 'dest' slice contains only one element, it should be a pointer to a struct or a []byte
 // where the entire response can be stored.
 //
 // It handles QFPay-specific error responses and returns a nil error on successful requests.
+// An HTMLResponseError is returned if the response is an HTML page.
+//
+// If Retry is set on the Client, failed requests are retried according to the policy.
+// Transport errors of payments, refunds and other requests that change data are
+// only retried if the connection could not be made, see RetryPolicy.
 //
-// If Debug is enabled on the Client, the function will log HTTP request and response details.
+// If Debug is enabled on the Client, or the request context was made by WithDebug, the
+// function will log HTTP request and response details.
 func (req *Request) Do(dest ...interface{}) error {
-	if req.client.Debug {
-		dump, err := httputil.DumpRequestOut(req.Request, true)
-		if err != nil {
-			return err
+	if len(dest) > 1 {
+		if len(dest)%2 != 0 {
+			return ValidationError{"dest must be pairs of pointer and key"}
+		}
+		for n := 1; n < len(dest); n += 2 {
+			if _, ok := dest[n].(string); !ok {
+				return validationErrorf("dest[%d] must be a key string, got %T", n, dest[n])
+			}
 		}
-		log.Println(string(dump))
-	}
-	res, err := http.DefaultClient.Do(req.Request)
-	if err != nil {
-		return err
 	}
-	defer res.Body.Close()
-	if req.client.Debug {
-		dumpBody := strings.Contains(res.Header.Get("Content-Type"), "json")
-		dump, err := httputil.DumpResponse(res, dumpBody)
-		if err != nil {
-			return err
+	b, err := req.send()
+	for attempt := 1; req.retryable(err, attempt); attempt++ {
+		if req.rewind() != nil {
+			break
 		}
-		log.Println(string(dump))
+		select {
+		case <-req.Context().Done():
+			return req.Context().Err()
+		case <-time.After(req.client.Retry.nextDelay(attempt)):
+		}
+		b, err = req.send()
 	}
-	b, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return err
 	}
-	var respError QFError
-	json.Unmarshal(b, &respError)
-	if respError.Code != "0000" {
-		return respError
+	if req.client.StrictValidation && req.outTradeNo != "" {
+		var echo struct {
+			OutTradeNo string `json:"out_trade_no"`
+		}
+		json.Unmarshal(b, &echo)
+		if echo.OutTradeNo != req.outTradeNo {
+			return MismatchError{"out_trade_no", req.outTradeNo, echo.OutTradeNo}
+		}
 	}
 	if len(dest) == 0 {
 		return nil
 	}
 	if len(dest) > 1 {
 		for n := 0; n < len(dest)/2; n++ {
-			arrange(b, dest[2*n], dest[2*n+1].(string))
+			if err := req.client.decodePath(b, dest[2*n], dest[2*n+1].(string)); err != nil {
+				return err
+			}
 		}
 		return nil
 	}
@@ -260,15 +1265,270 @@ func (req *Request) Do(dest ...interface{}) error {
 		*x = b
 		return nil
 	}
-	return json.Unmarshal(b, dest[0])
+	return req.client.unmarshal(b, dest[0])
+}
+
+// decodePath stores the values at key of the JSON data into target, checking
+// for unknown fields if StrictJSON is set.
+func (c *Client) decodePath(data []byte, target interface{}, key string) error {
+	if err := arrange(data, target, key); err != nil {
+		return err
+	}
+	if c.StrictJSON {
+		return checkArranged(data, target, key)
+	}
+	return nil
+}
+
+// DoRaw is like Do with a single destination, but also returns the raw
+// response body, e.g. to archive it alongside the parsed value.
+func (req *Request) DoRaw(dest interface{}) ([]byte, error) {
+	var b []byte
+	if err := req.Do(&b); err != nil {
+		return nil, err
+	}
+	if dest == nil {
+		return b, nil
+	}
+	return b, req.client.unmarshal(b, dest)
+}
+
+type debugKey struct{}
+
+// WithDebug returns a context that enables debug logging for the requests
+// made with it, regardless of Client.Debug.
+func WithDebug(ctx context.Context) context.Context {
+	return context.WithValue(ctx, debugKey{}, true)
+}
+
+// debug reports whether debug logging is enabled for the request by its
+// context or the client.
+func (req *Request) debug() bool {
+	enabled, _ := req.Context().Value(debugKey{}).(bool)
+	return enabled || req.client.Debug
+}
+
+// unmarshal decodes the response body into v, rejecting unknown fields if
+// StrictJSON is set.
+func (c *Client) unmarshal(b []byte, v interface{}) error {
+	if err := json.Unmarshal(b, v); err != nil {
+		return err
+	}
+	if !c.StrictJSON || v == nil {
+		return nil
+	}
+	return checkUnknownFields(b, reflect.TypeOf(v))
+}
+
+// send performs a single round trip and returns the response body, or an
+// error if the request failed or QFPay responded with an error.
+func (req *Request) send() (_ []byte, err error) {
+	req.attempts++
+	if req.debug() {
+		if deadline, ok := req.Context().Deadline(); ok {
+			log.Println("context deadline in", time.Until(deadline).Round(time.Millisecond))
+		}
+		dump, err := req.dump()
+		if err != nil {
+			return nil, err
+		}
+		log.Println(dump)
+	}
+	start := time.Now()
+	status := 0
+	defer func() {
+		req.client.record(req.URL.Path, status, time.Since(start), err)
+	}()
+	res, err := req.client.httpClient().Do(req.Request)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	status = res.StatusCode
+	req.requestID = responseRequestID(res.Header)
+	if req.debug() {
+		dumpBody := strings.Contains(res.Header.Get("Content-Type"), "json")
+		dump, err := httputil.DumpResponse(res, dumpBody)
+		if err != nil {
+			return nil, err
+		}
+		log.Println(req.client.maskDump(string(dump)))
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.response, req.responseBody = res, b
+	if strings.Contains(res.Header.Get("Content-Type"), "text/html") {
+		snippet := b
+		if len(snippet) > 200 {
+			snippet = snippet[:200]
+		}
+		return nil, HTMLResponseError{res.StatusCode, strings.TrimSpace(string(snippet))}
+	}
+	// decode the status into its own struct rather than QFError, whose
+	// UnmarshalJSON also accepts the shape of MarshalJSON
+	var respStatus struct {
+		Respcd  string `json:"respcd"`
+		Resperr string `json:"resperr"`
+		Respmsg string `json:"respmsg"`
+	}
+	json.Unmarshal(b, &respStatus)
+	if respStatus.Respcd != "0000" {
+		return nil, QFError{respStatus.Respcd, respStatus.Resperr, respStatus.Respmsg, req.requestID}
+	}
+	return b, nil
+}
+
+// requestIDHeaders are the headers that may carry the ID of a response.
+var requestIDHeaders = []string{"X-Request-Id", "X-Trace-Id", "X-QF-Request-Id"}
+
+func responseRequestID(header http.Header) string {
+	for _, name := range requestIDHeaders {
+		if id := header.Get(name); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// debugBodyLimit is the maximum number of bytes of a request body to dump in
+// debug mode.
+const debugBodyLimit = 4096
+
+// dump returns the request headers and up to debugBodyLimit bytes of the
+// body, with the app code masked. The body is read from a copy obtained from
+// GetBody, so that a body that cannot be replayed is never consumed.
+func (req *Request) dump() (string, error) {
+	dump, err := req.dumpUnmasked()
+	if err != nil {
+		return "", err
+	}
+	return req.client.maskDump(dump), nil
+}
+
+// maskDump masks the app code wherever it appears in a request or response
+// dump.
+func (c *Client) maskDump(dump string) string {
+	if c.AppCode == "" {
+		return dump
+	}
+	return strings.ReplaceAll(dump, c.AppCode, mask(c.AppCode))
+}
+
+func (req *Request) dumpUnmasked() (string, error) {
+	dump, err := httputil.DumpRequestOut(req.Request, false)
+	if err != nil {
+		return "", err
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return string(dump), nil
+	}
+	if req.GetBody == nil {
+		return string(dump) + "[body not replayable, not dumped]", nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(body, debugBodyLimit+1))
+	if err != nil {
+		return "", err
+	}
+	if len(b) > debugBodyLimit {
+		return string(dump) + string(b[:debugBodyLimit]) + "... [truncated]", nil
+	}
+	return string(dump) + string(b), nil
+}
+
+// DebugString returns the dump of the request as logged in debug mode,
+// without sending it. The app code is masked.
+func (req *Request) DebugString() (string, error) {
+	return req.dump()
+}
+
+// ResponseDump returns the dump of the last response received by Do, or an
+// empty string if no response was received. The app code is masked as in
+// DebugString.
+func (req *Request) ResponseDump() (string, error) {
+	if req.response == nil {
+		return "", nil
+	}
+	res := *req.response
+	res.Body = ioutil.NopCloser(bytes.NewReader(req.responseBody))
+	dump, err := httputil.DumpResponse(&res, true)
+	if err != nil {
+		return "", err
+	}
+	return req.client.maskDump(string(dump)), nil
+}
+
+// rewind resets the request body so that the request can be sent again.
+func (req *Request) rewind() error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	if req.GetBody == nil {
+		return errors.New("qfpayslim: request body cannot be replayed")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
+
+// mergeExtra returns a new map with the fields of extra and fields, the
+// latter taking precedence.
+func mergeExtra(extra, fields map[string]string) map[string]string {
+	merged := make(map[string]string, len(extra)+len(fields))
+	for k, v := range extra {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return merged
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// dedup returns values without duplicates, keeping the first occurrence.
+func dedup(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
 }
 
 func reqBodyToReader(reqBody interface{}) (io.Reader, error) {
 	if reqBody == nil {
 		return nil, nil
 	}
-	if r, ok := reqBody.(io.Reader); ok {
-		return r, nil
+	switch r := reqBody.(type) {
+	case *bytes.Buffer, *bytes.Reader, *strings.Reader:
+		// http.NewRequest sets GetBody for these, so they can be replayed
+		return r.(io.Reader), nil
+	case io.Reader:
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(b), nil
 	}
 	b, err := json.Marshal(reqBody)
 	if err != nil {
@@ -277,9 +1537,20 @@ func reqBodyToReader(reqBody interface{}) (io.Reader, error) {
 	return bytes.NewReader(b), nil
 }
 
-func arrange(data []byte, target interface{}, key string) {
+// arrange stores the values at key of the JSON data into target, which must
+// be a pointer. It never panics on malformed data or keys: an error is
+// returned if the key cannot be resolved, as by extract, while values that do
+// not match the type of target leave the zero value.
+func arrange(data []byte, target interface{}, key string) error {
 	keys := strings.Split(key, ".")
-	baseType := reflect.TypeOf(target).Elem()
+	targetType := reflect.TypeOf(target)
+	if targetType == nil || targetType.Kind() != reflect.Ptr {
+		return validationErrorf("destination for %s must be a pointer, got %T", key, target)
+	}
+	if _, err := extract(data, keys); err != nil {
+		return err
+	}
+	baseType := targetType.Elem()
 	if baseType.Kind() == reflect.Slice {
 		baseType = baseType.Elem()
 	}
@@ -297,7 +1568,7 @@ func arrange(data []byte, target interface{}, key string) {
 	items := collect(d.Elem(), keys)
 	v := reflect.Indirect(reflect.ValueOf(target))
 	if !v.IsValid() {
-		return
+		return nil
 	}
 	for n := range items {
 		item := items[n]
@@ -310,10 +1581,19 @@ func arrange(data []byte, target interface{}, key string) {
 			v.Set(item)
 		}
 	}
+	return nil
 }
 
+// collect returns the values at keys of x, an invalid value for a missing
+// key, or no values for a missing array.
 func collect(x reflect.Value, keys []string) (out []reflect.Value) {
 	for i, key := range keys {
+		if !x.IsValid() {
+			if key == "*" {
+				return
+			}
+			break
+		}
 		if key == "*" {
 			k := keys[i+1:]
 			for i := 0; i < x.Len(); i++ {
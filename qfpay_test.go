@@ -0,0 +1,1154 @@
+package qfpayslim
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testAppCode = "0123456789ABCDEF0123456789ABCDEF"
+	testKey     = "FEDCBA9876543210FEDCBA9876543210"
+)
+
+// newTestClient returns a client sending its requests to a test server that
+// runs handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &Client{
+		Prefix:        srv.URL,
+		AppCode:       testAppCode,
+		Key:           testKey,
+		AllowInsecure: true,
+	}
+}
+
+// respond returns a handler that writes body with the content type.
+func respond(contentType, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}
+}
+
+// respondJSON returns a handler that writes body as JSON.
+func respondJSON(body string) http.HandlerFunc {
+	return respond("application/json", body)
+}
+
+func TestTxdtm(t *testing.T) {
+	now := time.Date(2024, 1, 2, 16, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name     string
+		location *time.Location
+		want     string
+	}{
+		{"default is Hong Kong", nil, "2024-01-03 00:04:05"},
+		{"UTC", time.UTC, "2024-01-02 16:04:05"},
+		{"fixed zone", time.FixedZone("UTC-5", -5*60*60), "2024-01-02 11:04:05"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{Location: tt.location}
+			if got := c.txdtm(now); got != tt.want {
+				t.Errorf("txdtm = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLResponseError(t *testing.T) {
+	page := "<html><body>" + strings.Repeat("Access denied. ", 50) + "</body></html>"
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(page))
+	})
+	var res PaymentResponse
+	err := c.PostForm(context.Background(), "/trade/v1/payment", nil, &res)
+	var htmlErr HTMLResponseError
+	if !errors.As(err, &htmlErr) {
+		t.Fatalf("err = %v, want HTMLResponseError", err)
+	}
+	if htmlErr.StatusCode != http.StatusForbidden {
+		t.Errorf("StatusCode = %d, want %d", htmlErr.StatusCode, http.StatusForbidden)
+	}
+	if htmlErr.Snippet != page[:200] {
+		t.Errorf("Snippet = %q, want the first 200 bytes of the page", htmlErr.Snippet)
+	}
+	if !strings.HasPrefix(err.Error(), "qfpayslim: unexpected HTML response (Status=403): <html>") {
+		t.Errorf("Error() = %q", err.Error())
+	}
+}
+
+func TestQueryNumericValues(t *testing.T) {
+	c := newTestClient(t, respondJSON(`{"respcd":"0000","data":[
+		{"out_trade_no":"A1","syssn":"20240102000100020000000001","txamt":1050,"refund_amt":0,"txcurrcd":"HKD","respcd":"0000","cancel":0}
+	]}`))
+	responses, err := c.Query(context.Background(), "A1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 {
+		t.Fatalf("got %d responses, want 1", len(responses))
+	}
+	res := responses[0]
+	if res.Txamt != "1050" || res.RefundAmt != "0" || res.Cancel != "0" {
+		t.Errorf("Txamt, RefundAmt, Cancel = %q, %q, %q, want 1050, 0, 0", res.Txamt, res.RefundAmt, res.Cancel)
+	}
+	if cents, err := res.AmountCents(); err != nil || cents != 1050 {
+		t.Errorf("AmountCents = %d, %v, want 1050", cents, err)
+	}
+}
+
+func TestQueryResponseAliases(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want QueryResponse
+	}{
+		{
+			"alternative spellings",
+			`{"sys_sn":"S1","chnl_sn":"C1","chnl_sn2":"C2","tx_amt":100,"tx_currcd":"HKD","tx_dtm":"2024-01-02 03:04:05","pay_dtm":"2024-01-02 03:04:06","sys_dtm":"2024-01-02 03:04:07"}`,
+			QueryResponse{Syssn: "S1", Chnlsn: "C1", Chnlsn2: "C2", Txamt: "100", Txcurrcd: "HKD", Txdtm: "2024-01-02 03:04:05", Paydtm: "2024-01-02 03:04:06", Sysdtm: "2024-01-02 03:04:07"},
+		},
+		{
+			"canonical key wins",
+			`{"tx_amt":"100","txamt":"200","syssn":"S2","sys_sn":"S1"}`,
+			QueryResponse{Syssn: "S2", Txamt: "200"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res QueryResponse
+			if err := json.Unmarshal([]byte(tt.data), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res != tt.want {
+				t.Errorf("got %+v, want %+v", res, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebugStringLargeBody(t *testing.T) {
+	body := strings.Repeat("a", 2*debugBodyLimit)
+	var received string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+		respondJSON(`{"respcd":"0000"}`)(w, r)
+	})
+	req, err := c.NewRequest(context.Background(), "POST", "/trade/v1/payment", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump, err := req.DebugString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(dump, strings.Repeat("a", debugBodyLimit)+"... [truncated]") || strings.Contains(dump, strings.Repeat("a", debugBodyLimit+1)) {
+		t.Errorf("dump is not truncated at %d bytes", debugBodyLimit)
+	}
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+	if received != body {
+		t.Errorf("server received %d bytes, want %d", len(received), len(body))
+	}
+}
+
+func TestDebugStringNotReplayable(t *testing.T) {
+	c := &Client{Prefix: "https://test.qfapi.com"}
+	req, err := c.NewRequest(context.Background(), "POST", "/trade/v1/payment", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Body = io.NopCloser(strings.NewReader("payload"))
+	dump, err := req.DebugString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(dump, "[body not replayable, not dumped]") {
+		t.Errorf("dump = %q", dump)
+	}
+	if b, _ := io.ReadAll(req.Body); string(b) != "payload" {
+		t.Errorf("body = %q after dump, want it unread", b)
+	}
+}
+
+// md5Sign returns the uppercase hex MD5 of s, computed independently of hash.
+func md5Sign(s string) string {
+	return fmt.Sprintf("%X", md5.Sum([]byte(s)))
+}
+
+func TestSignJSON(t *testing.T) {
+	type item struct {
+		Name  string `json:"name"`
+		Price int    `json:"price"`
+	}
+	body := struct {
+		Zone  string          `json:"zone"`
+		Items []item          `json:"items"`
+		Extra map[string]bool `json:"extra"`
+		Note  string          `json:"note"`
+		Sign  string          `json:"sign"`
+		Skip  string          `json:"skip"`
+	}{
+		Zone:  "HK",
+		Items: []item{{"A&B", 100}},
+		Extra: map[string]bool{"z": true, "a": false},
+		Note:  "<b>",
+		Sign:  "IGNORED",
+		Skip:  "excluded",
+	}
+	canonical := `{"extra":{"a":false,"z":true},"items":[{"name":"A&B","price":100}],"note":"<b>","zone":"HK"}`
+	tests := []struct {
+		name     string
+		position KeyPosition
+		want     string
+	}{
+		{"key suffix", KeySuffix, md5Sign(canonical + testKey)},
+		{"key prefix", KeyPrefix, md5Sign(testKey + canonical)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{Key: testKey, KeyPosition: tt.position, SignExclude: []string{"skip"}}
+			for i := 0; i < 10; i++ {
+				got, err := c.SignJSON(body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want {
+					t.Fatalf("SignJSON = %s, want %s", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewJSONRequest(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if string(b) != `{"a":1,"b":"<&>"}` {
+			t.Errorf("body = %s", b)
+		}
+		if got, want := r.Header.Get("X-QF-SIGN"), md5Sign(string(b)+testKey); got != want {
+			t.Errorf("X-QF-SIGN = %s, want %s", got, want)
+		}
+		respondJSON(`{"respcd":"0000"}`)(w, r)
+	})
+	req, err := c.NewJSONRequest(context.Background(), "POST", "/json", map[string]interface{}{"b": "<&>", "a": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryDedup(t *testing.T) {
+	var sent []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.FormValue("out_trade_no")+"|"+r.FormValue("syssn"))
+		respondJSON(`{"respcd":"0000","data":[]}`)(w, r)
+	})
+	ctx := context.Background()
+	if _, err := c.Query(ctx, "A1", "A2", "A1", "A3", "A2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.QuerySyssn(ctx, "S1", "S1", "S2"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"A1,A2,A3|", "|S1,S2"}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %q, want %q", sent, want)
+	}
+}
+
+func TestStrictValidationEcho(t *testing.T) {
+	tests := []struct {
+		name   string
+		strict bool
+		echo   string
+		want   error
+	}{
+		{"matching echo", true, "A1", nil},
+		{"mismatched echo", true, "B1", MismatchError{"out_trade_no", "A1", "B1"}},
+		{"missing echo", true, "", MismatchError{"out_trade_no", "A1", ""}},
+		{"not strict", false, "B1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, respondJSON(`{"respcd":"0000","out_trade_no":"`+tt.echo+`"}`))
+			c.StrictValidation = tt.strict
+			req, err := c.MakePayment(context.Background(), PayTypeAlipayQRCode, "A1", "Goods", 100, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var res PaymentResponse
+			if err := req.Do(&res); err != tt.want {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStrictValidationQuery(t *testing.T) {
+	c := newTestClient(t, respondJSON(`{"respcd":"0000","data":[{"out_trade_no":"A1"},{"out_trade_no":"B1"}]}`))
+	c.StrictValidation = true
+	_, err := c.Query(context.Background(), "A1", "A2")
+	var mismatch MismatchError
+	if !errors.As(err, &mismatch) || mismatch.Got != "B1" {
+		t.Errorf("err = %v, want a MismatchError for B1", err)
+	}
+}
+
+func TestClientString(t *testing.T) {
+	tests := []struct {
+		name    string
+		appCode string
+		key     string
+		want    string
+	}{
+		{"long secrets", testAppCode, testKey, "qfpayslim.Client{Prefix: https://test.qfapi.com, AppCode: 0123" + strings.Repeat("*", 24) + "CDEF, Key: FEDC" + strings.Repeat("*", 24) + "3210}"},
+		{"short secrets", "APPCODE", "KEY", "qfpayslim.Client{Prefix: https://test.qfapi.com, AppCode: *******, Key: ***}"},
+		{"no secrets", "", "", "qfpayslim.Client{Prefix: https://test.qfapi.com, AppCode: , Key: }"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{Prefix: "https://test.qfapi.com", AppCode: tt.appCode, Key: tt.key, SecondaryKey: tt.key}
+			for _, s := range []string{c.String(), fmt.Sprint(c), fmt.Sprintf("%v", *c), fmt.Sprintf("%s", c)} {
+				if s != tt.want {
+					t.Errorf("got %q, want %q", s, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDoMultiplePaths(t *testing.T) {
+	c := newTestClient(t, respondJSON(`{"respcd":"0000","data":{"total":3,"list":[
+		{"out_trade_no":"A1","txamt":"100"},
+		{"out_trade_no":"A2","txamt":"200"},
+		{"out_trade_no":"A3","txamt":"300"}
+	]}}`))
+	req, err := c.NewFormRequest(context.Background(), "/trade/v1/tradelist", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int
+	var items []QueryResponse
+	var numbers []string
+	if err := req.Do(&total, "data.total", &items, "data.list.*", &numbers, "data.list.*.out_trade_no"); err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 {
+		t.Errorf("total = %d, want 3", total)
+	}
+	if len(items) != 3 || items[2].Txamt != "300" {
+		t.Errorf("items = %+v", items)
+	}
+	if !reflect.DeepEqual(numbers, []string{"A1", "A2", "A3"}) {
+		t.Errorf("numbers = %q", numbers)
+	}
+
+	for _, key := range []string{"data.missing", "data.list.*.missing", "data.total.*"} {
+		req, err := c.NewFormRequest(context.Background(), "/trade/v1/tradelist", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var missing string
+		if err := req.Do(&total, "data.total", &missing, key); err == nil {
+			t.Errorf("%s: no error", key)
+		}
+	}
+}
+
+func TestDoMalformedPairs(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request sent with malformed pairs")
+	})
+	var total int
+	for _, dest := range [][]interface{}{
+		{&total, "data.total", &total},
+		{&total, 1},
+	} {
+		req, err := c.NewFormRequest(context.Background(), "/trade/v1/tradelist", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var validationErr ValidationError
+		if err := req.Do(dest...); !errors.As(err, &validationErr) {
+			t.Errorf("Do(%v) = %v, want a ValidationError", dest, err)
+		}
+	}
+}
+
+func TestRefundedAmountCents(t *testing.T) {
+	tests := []struct {
+		name string
+		res  QueryResponse
+		want int
+	}{
+		{"not refunded", QueryResponse{Txamt: "1000", Txcurrcd: "HKD"}, 0},
+		{"partially refunded", QueryResponse{Txamt: "1000", RefundAmt: "250", Txcurrcd: "HKD"}, 250},
+		{"fully refunded", QueryResponse{Txamt: "1000", RefundAmt: "1000", Txcurrcd: "HKD"}, 1000},
+		{"zero decimal currency", QueryResponse{Txamt: "100", RefundAmt: "40", Txcurrcd: "JPY"}, 4000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.res.RefundedAmountCents()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("RefundedAmountCents = %d, want %d", got, tt.want)
+			}
+		})
+	}
+	if _, err := (QueryResponse{RefundAmt: "1.5"}).RefundedAmountCents(); err == nil {
+		t.Error("RefundedAmountCents of 1.5 returned no error")
+	}
+}
+
+func TestVerifySign(t *testing.T) {
+	values := url.Values{"txamt": {"100"}, "out_trade_no": {"A1"}, "txcurrcd": {"HKD"}, "sign": {"ignored"}}
+	const (
+		md5Vector    = "AEB33D5A790BFBEC6F51AA7EDB781CFE"
+		sha256Vector = "DC6B10598D84EEF9F7526F02FFCA1601BECE222731E28F6C9A5353CE89F5C128"
+	)
+	tests := []struct {
+		name     string
+		key      string
+		signType string
+		expected string
+		want     bool
+	}{
+		{"MD5", "KEY", "MD5", md5Vector, true},
+		{"MD5 lowercase", "KEY", "md5", strings.ToLower(md5Vector), true},
+		{"SHA256", "KEY", "SHA256", sha256Vector, true},
+		{"wrong key", "OTHER", "MD5", md5Vector, false},
+		{"wrong sign type", "KEY", "SHA256", md5Vector, false},
+		{"unsupported sign type", "KEY", "SHA1", md5Vector, false},
+		{"empty signature", "KEY", "MD5", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifySign(values, tt.key, tt.signType, tt.expected); got != tt.want {
+				t.Errorf("VerifySign = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyPosition(t *testing.T) {
+	payload := url.Values{"out_trade_no": {"A1"}, "txamt": {"100"}}
+	content := "out_trade_no=A1&txamt=100"
+	tests := []struct {
+		name     string
+		position KeyPosition
+		want     string
+	}{
+		{"suffix", KeySuffix, md5Sign(content + testKey)},
+		{"prefix", KeyPrefix, md5Sign(testKey + content)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("X-QF-SIGN"); got != tt.want {
+					t.Errorf("X-QF-SIGN = %s, want %s", got, tt.want)
+				}
+				respondJSON(`{"respcd":"0000"}`)(w, r)
+			})
+			c.KeyPosition = tt.position
+			if got := c.GenerateSign(payload); got != tt.want {
+				t.Errorf("GenerateSign = %s, want %s", got, tt.want)
+			}
+			if err := c.PostForm(context.Background(), "/trade/v1/query", payload); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPaidWith(t *testing.T) {
+	tests := []struct {
+		respcd string
+		codes  []string
+		want   bool
+	}{
+		{"0000", nil, true},
+		{"1143", nil, false},
+		{"0000", []string{"0000", "1143"}, true},
+		{"1143", []string{"0000", "1143"}, true},
+		{"0000", []string{"1143"}, false},
+		{"1145", []string{"0000", "1143"}, false},
+	}
+	for _, tt := range tests {
+		res := QueryResponse{Respcd: tt.respcd}
+		if got := res.PaidWith(tt.codes...); got != tt.want {
+			t.Errorf("PaidWith(%q) of %s = %v, want %v", tt.codes, tt.respcd, got, tt.want)
+		}
+		c := &Client{PaidCodes: tt.codes}
+		if got := c.IsPaid(res); got != tt.want {
+			t.Errorf("IsPaid with PaidCodes %q of %s = %v, want %v", tt.codes, tt.respcd, got, tt.want)
+		}
+	}
+}
+
+func TestQFErrorJSON(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req-1")
+		respondJSON(`{"respcd":"1108","resperr":"Invalid parameters","respmsg":"txamt"}`)(w, r)
+	})
+	err := c.PostForm(context.Background(), "/trade/v1/payment", nil)
+	var qfErr QFError
+	if !errors.As(err, &qfErr) {
+		t.Fatalf("err = %v, want QFError", err)
+	}
+	want := QFError{"1108", "Invalid parameters", "txamt", "req-1"}
+	if qfErr != want {
+		t.Errorf("err = %+v, want %+v", qfErr, want)
+	}
+	b, err := json.Marshal(qfErr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"code":"1108","error":"Invalid parameters","message":"txamt","request_id":"req-1"}` {
+		t.Errorf("MarshalJSON = %s", b)
+	}
+	var decoded QFError
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != want {
+		t.Errorf("round trip = %+v, want %+v", decoded, want)
+	}
+
+	b, _ = json.Marshal(QFError{Code: "1143", Err: "Pending"})
+	if string(b) != `{"code":"1143","error":"Pending","message":""}` {
+		t.Errorf("MarshalJSON without request ID = %s", b)
+	}
+}
+
+func TestResponseStatusIgnoresErrorShape(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want error
+	}{
+		{"numeric code", `{"respcd":"0000","code":0,"qrcode":"x"}`, nil},
+		{"string code", `{"respcd":"0000","code":"1108","error":"e","message":"m"}`, nil},
+		{"error with code", `{"respcd":"1108","resperr":"Invalid parameters","code":0}`, QFError{Code: "1108", Err: "Invalid parameters"}},
+		{"only the error shape", `{"code":"0000"}`, QFError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, respondJSON(tt.body))
+			var res PaymentResponse
+			if err := c.PostForm(context.Background(), "/trade/v1/payment", nil, &res); err != tt.want {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	const oldKey, newKey = "OLDKEY", "NEWKEY"
+	body := []byte(`{"out_trade_no":"A1","txamt":"100","txcurrcd":"HKD","respcd":"0000"}`)
+	returnValues := url.Values{"out_trade_no": {"A1"}, "respcd": {"0000"}}
+	c := &Client{Key: newKey, SecondaryKey: oldKey}
+	for _, key := range []string{oldKey, newKey} {
+		if !c.VerifyCallback(body, md5Sign(string(body)+key)) {
+			t.Errorf("callback signed with %s rejected", key)
+		}
+		u := &url.URL{RawQuery: returnValues.Encode() + "&sign=" + md5Sign("out_trade_no=A1&respcd=0000"+key)}
+		if _, err := c.ParseReturnURL(u); err != nil {
+			t.Errorf("return URL signed with %s rejected: %v", key, err)
+		}
+	}
+	if c.VerifyCallback(body, md5Sign(string(body)+"OTHERKEY")) {
+		t.Error("callback signed with another key accepted")
+	}
+	c.SecondaryKey = ""
+	if c.VerifyCallback(body, md5Sign(string(body)+oldKey)) {
+		t.Error("callback signed with the old key accepted after rotation")
+	}
+	if c.VerifyCallback(body, md5Sign(string(body))) {
+		t.Error("callback signed with an empty key accepted")
+	}
+}
+
+func TestCallMethod(t *testing.T) {
+	for _, method := range []string{"GET", "POST", "PUT", "DELETE"} {
+		t.Run(method, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != method {
+					t.Errorf("method = %s, want %s", r.Method, method)
+				}
+				if r.URL.Path != "/trade/v1/custom" {
+					t.Errorf("path = %s", r.URL.Path)
+				}
+				respondJSON(`{"respcd":"0000","syssn":"S1"}`)(w, r)
+			})
+			var syssn string
+			if err := c.Call(context.Background(), method, "/trade/v1/custom", url.Values{"a": {"1"}}, &syssn, "syssn"); err != nil {
+				t.Fatal(err)
+			}
+			if syssn != "S1" {
+				t.Errorf("syssn = %q, want S1", syssn)
+			}
+		})
+	}
+}
+
+func TestNewSignedRequestRepresentation(t *testing.T) {
+	payload := url.Values{"out_trade_no": {"A1"}, "goods_name": {"T-shirt & cap"}}
+	want := md5Sign("goods_name=T-shirt & cap&out_trade_no=A1" + testKey)
+	tests := []struct {
+		method      string
+		query       string
+		body        string
+		contentType string
+	}{
+		{"GET", payload.Encode(), "", ""},
+		{"HEAD", payload.Encode(), "", ""},
+		{"DELETE", payload.Encode(), "", ""},
+		{"POST", "", payload.Encode(), "application/x-www-form-urlencoded"},
+		{"PUT", "", payload.Encode(), "application/x-www-form-urlencoded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				if r.URL.RawQuery != tt.query {
+					t.Errorf("query = %q, want %q", r.URL.RawQuery, tt.query)
+				}
+				if string(b) != tt.body {
+					t.Errorf("body = %q, want %q", b, tt.body)
+				}
+				if got := r.Header.Get("Content-Type"); got != tt.contentType {
+					t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+				}
+				if got := r.Header.Get("X-QF-SIGN"); got != want {
+					t.Errorf("X-QF-SIGN = %s, want %s", got, want)
+				}
+				if got := r.Header.Get("X-QF-APPCODE"); got != testAppCode {
+					t.Errorf("X-QF-APPCODE = %s", got)
+				}
+				respondJSON(`{"respcd":"0000"}`)(w, r)
+			})
+			req, err := c.NewSignedRequest(context.Background(), tt.method, "/trade/v1/query", payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := req.Do(); err != nil && tt.method != "HEAD" {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func FuzzArrange(f *testing.F) {
+	f.Add([]byte(`{"data":{"total":3,"list":[{"txamt":"100"},{"txamt":200}]}}`), "data.list.*.txamt")
+	f.Add([]byte(`{"data":[[1,2],[3]]}`), "data.*.*")
+	f.Add([]byte(`[{"a":null}]`), "*.a.b")
+	f.Add([]byte(`{"a":"b"}`), "..a.")
+	f.Add([]byte(`not json`), "*")
+	f.Fuzz(func(t *testing.T, data []byte, key string) {
+		var n int
+		var s string
+		var list []string
+		var res QueryResponse
+		var responses []QueryResponse
+		var v interface{}
+		_, want := extract(data, strings.Split(key, "."))
+		for _, target := range []interface{}{&n, &s, &list, &res, &responses, &v, (*int)(nil)} {
+			if err := arrange(data, target, key); (err != nil) != (want != nil) {
+				t.Fatalf("arrange(%q, %T, %q) = %v, want %v", data, target, key, err, want)
+			}
+		}
+		for _, target := range []interface{}{nil, n, list} {
+			if err := arrange(data, target, key); err == nil {
+				t.Fatalf("arrange into %T returned no error", target)
+			}
+		}
+	})
+}
+
+func FuzzCollect(f *testing.F) {
+	f.Add([]byte(`{"data":{"list":[{"a":"1"},{"a":"2"}]}}`), "data.list.*.a")
+	f.Add([]byte(`{"data":[["1"],["2","3"]]}`), "data.*.*")
+	f.Add([]byte(`{"a":"1"}`), "a.b.c")
+	f.Add([]byte(`[]`), "*.*")
+	f.Fuzz(func(t *testing.T, data []byte, key string) {
+		keys := strings.Split(key, ".")
+		typ := reflect.TypeOf("")
+		for i := len(keys) - 1; i > -1; i-- {
+			if keys[i] == "*" {
+				typ = reflect.SliceOf(typ)
+			} else if keys[i] != "" {
+				typ = reflect.MapOf(reflect.TypeOf(""), typ)
+			}
+		}
+		d := reflect.New(typ)
+		json.Unmarshal(data, d.Interface())
+		items := collect(d.Elem(), keys)
+		if !strings.Contains(key, "*") && len(items) != 1 {
+			t.Fatalf("collect(%q) returned %d values, want 1", key, len(items))
+		}
+		for _, item := range items {
+			if item.IsValid() && item.Kind() != reflect.String {
+				t.Fatalf("collect(%q) returned a %s", key, item.Kind())
+			}
+		}
+	})
+}
+
+func TestTimeFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout string
+		want   string
+	}{
+		{"default", "", DefaultTimeFormat},
+		{"legacy", "20060102150405", "20060102150405"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var txdtm string
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				txdtm = r.FormValue("txdtm")
+				respondJSON(`{"respcd":"0000"}`)(w, r)
+			})
+			c.TimeFormat = tt.layout
+			req, err := c.MakePayment(context.Background(), PayTypeAlipayQRCode, "A1", "Goods", 100, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := req.Do(); err != nil {
+				t.Fatal(err)
+			}
+			sent, err := time.ParseInLocation(tt.want, txdtm, hongKong)
+			if err != nil {
+				t.Fatalf("txdtm %q does not match %q: %v", txdtm, tt.want, err)
+			}
+			if d := time.Since(sent); d < -time.Second || d > time.Minute {
+				t.Errorf("txdtm %q is %s from now", txdtm, d)
+			}
+		})
+	}
+	c := &Client{TimeFormat: "2006/01/02 15:04"}
+	if got := c.txdtm(time.Date(2024, 1, 2, 16, 4, 5, 0, time.UTC)); got != "2024/01/03 00:04" {
+		t.Errorf("txdtm = %q, want 2024/01/03 00:04", got)
+	}
+}
+
+func TestDoRaw(t *testing.T) {
+	const body = `{"respcd":"0000","syssn":"S1","qrcode":"https://qr.example/1"}`
+	c := newTestClient(t, respondJSON(body))
+	newRequest := func() *Request {
+		req, err := c.NewFormRequest(context.Background(), "/trade/v1/payment", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	var res PaymentResponse
+	raw, err := newRequest().DoRaw(&res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != body {
+		t.Errorf("raw = %s, want %s", raw, body)
+	}
+	if res.Syssn != "S1" || res.QRCode != "https://qr.example/1" {
+		t.Errorf("res = %+v", res)
+	}
+	if raw, err := newRequest().DoRaw(nil); err != nil || string(raw) != body {
+		t.Errorf("DoRaw(nil) = %s, %v", raw, err)
+	}
+	var wrong []string
+	if raw, err := newRequest().DoRaw(&wrong); err == nil || string(raw) != body {
+		t.Errorf("DoRaw into a slice = %s, %v, want the body and an error", raw, err)
+	}
+
+	failing := newTestClient(t, respondJSON(`{"respcd":"1108"}`))
+	req, err := failing.NewFormRequest(context.Background(), "/trade/v1/payment", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, err := req.DoRaw(&res); err == nil || raw != nil {
+		t.Errorf("DoRaw of an error response = %s, %v", raw, err)
+	}
+}
+
+// captureLog redirects the standard logger to a buffer for the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestWithDebug(t *testing.T) {
+	c := newTestClient(t, respondJSON(`{"respcd":"0000","syssn":"S1"}`))
+	tests := []struct {
+		name  string
+		debug bool
+		ctx   context.Context
+		want  bool
+	}{
+		{"disabled", false, context.Background(), false},
+		{"client", true, context.Background(), true},
+		{"context", false, WithDebug(context.Background()), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureLog(t)
+			client := c.Clone()
+			client.Debug = tt.debug
+			if err := client.PostForm(tt.ctx, "/trade/v1/query", url.Values{"syssn": {"S1"}}); err != nil {
+				t.Fatal(err)
+			}
+			logged := buf.String()
+			if got := strings.Contains(logged, "POST /trade/v1/query"); got != tt.want {
+				t.Errorf("request logged = %v, want %v:\n%s", got, tt.want, logged)
+			}
+			if got := strings.Contains(logged, `"syssn":"S1"`); got != tt.want {
+				t.Errorf("response logged = %v, want %v:\n%s", got, tt.want, logged)
+			}
+			if strings.Contains(logged, testAppCode) {
+				t.Errorf("app code logged unmasked:\n%s", logged)
+			}
+		})
+	}
+	buf := captureLog(t)
+	if err := c.PostForm(context.Background(), "/trade/v1/query", nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("debug context leaked into another call:\n%s", buf)
+	}
+}
+
+func TestSignExclude(t *testing.T) {
+	payload := url.Values{"out_trade_no": {"A1"}, "txamt": {"100"}, "return_url": {"https://shop.example/done"}}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("return_url") == "" {
+			t.Error("excluded key not sent")
+		}
+		if got, want := r.Header.Get("X-QF-SIGN"), md5Sign("out_trade_no=A1&txamt=100"+testKey); got != want {
+			t.Errorf("X-QF-SIGN = %s, want %s", got, want)
+		}
+		respondJSON(`{"respcd":"0000"}`)(w, r)
+	})
+	c.SignExclude = []string{"return_url"}
+	if err := c.PostForm(context.Background(), "/trade/v1/payment", payload); err != nil {
+		t.Fatal(err)
+	}
+	c.SignExclude = nil
+	if got, want := c.GenerateSign(payload), md5Sign("out_trade_no=A1&return_url=https://shop.example/done&txamt=100"+testKey); got != want {
+		t.Errorf("GenerateSign without SignExclude = %s, want %s", got, want)
+	}
+}
+
+func TestGenerateSignIgnoresSignature(t *testing.T) {
+	c := &Client{Key: testKey}
+	payload := url.Values{"out_trade_no": {"A1"}, "txamt": {"100"}}
+	want := c.GenerateSign(payload)
+	for _, key := range DefaultSignExclude {
+		signed := url.Values{"out_trade_no": {"A1"}, "txamt": {"100"}, key: {"ABCDEF"}}
+		if got := c.GenerateSign(signed); got != want {
+			t.Errorf("GenerateSign with %s = %s, want %s", key, got, want)
+		}
+	}
+	signed := url.Values{"out_trade_no": {"A1"}, "txamt": {"100"}}
+	signed.Set("sign", want)
+	if got := c.GenerateSign(signed); got != want {
+		t.Errorf("GenerateSign of a signed payload = %s, want %s", got, want)
+	}
+	if !VerifySign(signed, testKey, "MD5", want) {
+		t.Error("VerifySign rejected a payload containing its signature")
+	}
+}
+
+func TestStrictJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{"known fields", `{"respcd":"0000","data":[{"out_trade_no":"A1","tx_amt":100}]}`, false},
+		{"unknown envelope field", `{"respcd":"0000","page":1,"data":[]}`, false},
+		{"unknown record field", `{"respcd":"0000","data":[{"out_trade_no":"A1","surcharge":"10"}]}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, respondJSON(tt.body))
+			c.StrictJSON = true
+			_, err := c.Query(context.Background(), "A1")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Query err = %v, want error %v", err, tt.wantErr)
+			}
+			c.StrictJSON = false
+			if _, err := c.Query(context.Background(), "A1"); err != nil {
+				t.Errorf("Query without StrictJSON = %v", err)
+			}
+		})
+	}
+}
+
+func TestStrictJSONResponses(t *testing.T) {
+	envelope := `"respcd":"0000","resperr":"","respmsg":"","sysdtm":"2024-01-02 03:04:05","txdtm":"2024-01-02 03:04:05"`
+	responses := map[string]string{
+		"/trade/v1/query":    `{"respcd":"0000","data":[{"syssn":"S1","out_trade_no":"A1","order_type":"payment","respcd":"0000","txamt":"1000","txcurrcd":"HKD"}]}`,
+		"/trade/v1/refund":   `{` + envelope + `,"syssn":"S2","orig_syssn":"S1","out_trade_no":"R1","txamt":"500"}`,
+		"/trade/v1/close":    `{` + envelope + `,"syssn":"S1","out_trade_no":"A1"}`,
+		"/trade/v1/reversal": `{` + envelope + `,"syssn":"S3","orig_syssn":"S1","out_trade_no":"A1","txamt":"1000"}`,
+	}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(responses[r.URL.Path])(w, r)
+	})
+	c.StrictJSON = true
+	ctx := context.Background()
+	if _, err := c.Refund(ctx, "S1", "R1", 500, nil); err != nil {
+		t.Errorf("Refund = %v", err)
+	}
+	if _, err := c.Close(ctx, "A1", 1000, nil); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+	if _, err := c.Cancel(ctx, "A1", 1000, nil); err != nil {
+		t.Errorf("Cancel = %v", err)
+	}
+	responses["/trade/v1/close"] = `{` + envelope + `,"syssn":"S1","closed_by":"merchant"}`
+	var validationErr ValidationError
+	if _, err := c.Close(ctx, "A1", 1000, nil); !errors.As(err, &validationErr) {
+		t.Errorf("Close with an unknown field = %v, want a ValidationError", err)
+	}
+}
+
+func TestRetryReplaysBody(t *testing.T) {
+	const body = "out_trade_no=A1&txamt=100"
+	var bodies []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if len(bodies) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		respondJSON(`{"respcd":"0000"}`)(w, r)
+	})
+	c.Retry = &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	// io.MultiReader cannot be replayed by net/http on its own.
+	req, err := c.NewRequest(context.Background(), "POST", "/trade/v1/query", io.MultiReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+	if req.Attempts() != 2 {
+		t.Errorf("Attempts = %d, want 2", req.Attempts())
+	}
+	if want := []string{body, body}; !reflect.DeepEqual(bodies, want) {
+		t.Errorf("server received %q, want %q", bodies, want)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	attempts := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		respondJSON(`{"respcd":"1143","resperr":"In progress"}`)(w, r)
+	})
+	c.Retry = &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	c.RetryableCodes = []string{"1143"}
+	err := c.PostForm(context.Background(), "/trade/v1/payment", url.Values{"a": {"1"}})
+	var qfErr QFError
+	if !errors.As(err, &qfErr) || qfErr.Code != "1143" {
+		t.Errorf("err = %v, want QFError 1143", err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestQueryByType(t *testing.T) {
+	var orderType string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		orderType = r.FormValue("order_type")
+		respondJSON(`{"respcd":"0000","data":[
+			{"out_trade_no":"A1","syssn":"S1","order_type":"payment"},
+			{"out_trade_no":"A1","syssn":"S2","order_type":"refund","orig_syssn":"S1"}
+		]}`)(w, r)
+	})
+	tests := []struct {
+		orderType string
+		want      []string
+	}{
+		{"", []string{"S1", "S2"}},
+		{OrderTypePayment, []string{"S1"}},
+		{OrderTypeRefund, []string{"S2"}},
+	}
+	for _, tt := range tests {
+		responses, err := c.QueryByType(context.Background(), tt.orderType, "A1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if orderType != tt.orderType {
+			t.Errorf("order_type sent = %q, want %q", orderType, tt.orderType)
+		}
+		var got []string
+		for _, res := range responses {
+			got = append(got, res.Syssn)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("QueryByType(%q) = %q, want %q", tt.orderType, got, tt.want)
+		}
+	}
+	if responses, err := c.QueryByType(context.Background(), OrderTypeRefund); err != nil || responses != nil {
+		t.Errorf("QueryByType without order numbers = %v, %v", responses, err)
+	}
+}
+
+func TestMakeWechatAppPayment(t *testing.T) {
+	var payType string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		payType = r.FormValue("pay_type")
+		respondJSON(`{"respcd":"0000","pay_params":{"appid":"wx1","partnerid":"p1","prepayid":"wx2024","package":"Sign=WXPay","noncestr":"n1","timestamp":1704135845,"sign":"S"}}`)(w, r)
+	})
+	params, err := c.MakeWechatAppPayment(context.Background(), "A1", "Goods", 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := WechatAppPayment{"wx1", "p1", "wx2024", "Sign=WXPay", "n1", "1704135845", "S"}
+	if *params != want {
+		t.Errorf("params = %+v, want %+v", *params, want)
+	}
+	if payType != PayTypeWechatPayAPP {
+		t.Errorf("pay_type = %s, want %s", payType, PayTypeWechatPayAPP)
+	}
+
+	c = newTestClient(t, respondJSON(`{"respcd":"0000"}`))
+	if params, err := c.MakeWechatAppPayment(context.Background(), "A1", "Goods", 100, nil); err == nil {
+		t.Errorf("MakeWechatAppPayment without pay_params = %+v, want an error", params)
+	}
+}
+
+// testWechatBrowserPayment tests MakeWechatJSAPIPayment or
+// MakeWechatMiniProgramPayment, which make the payment of payType.
+func testWechatBrowserPayment(t *testing.T, payType string, makePayment func(*Client, string) (*WechatJSAPIPayment, error)) {
+	var sent url.Values
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sent = r.PostForm
+		respondJSON(`{"respcd":"0000","pay_params":{"appId":"wx1","timeStamp":"1704135845","nonceStr":"n1","package":"prepay_id=wx2024","signType":"MD5","paySign":"S"}}`)(w, r)
+	})
+	params, err := makePayment(c, "openid1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := WechatJSAPIPayment{"wx1", "1704135845", "n1", "prepay_id=wx2024", "MD5", "S"}
+	if *params != want {
+		t.Errorf("params = %+v, want %+v", *params, want)
+	}
+	if sent.Get("pay_type") != payType || sent.Get("sub_openid") != "openid1" {
+		t.Errorf("sent pay_type %s and sub_openid %s", sent.Get("pay_type"), sent.Get("sub_openid"))
+	}
+	var validationErr ValidationError
+	if _, err := makePayment(c, ""); !errors.As(err, &validationErr) {
+		t.Errorf("payment without openid = %v, want a ValidationError", err)
+	}
+
+	c = newTestClient(t, respondJSON(`{"respcd":"0000"}`))
+	if params, err := makePayment(c, "openid1"); err == nil {
+		t.Errorf("payment without pay_params = %+v, want an error", params)
+	}
+}
+
+func TestMakeWechatJSAPIPayment(t *testing.T) {
+	testWechatBrowserPayment(t, PayTypeWechatPayJSAPI, func(c *Client, openID string) (*WechatJSAPIPayment, error) {
+		return c.MakeWechatJSAPIPayment(context.Background(), openID, "A1", "Goods", 100, nil)
+	})
+}
+
+func TestMakeWechatMiniProgramPayment(t *testing.T) {
+	testWechatBrowserPayment(t, PayTypeWechatPayMini, func(c *Client, openID string) (*WechatJSAPIPayment, error) {
+		return c.MakeWechatMiniProgramPayment(context.Background(), openID, "A1", "Goods", 100, nil)
+	})
+}
+
+func TestClose(t *testing.T) {
+	var sent url.Values
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sent = r.PostForm
+		respondJSON(`{"respcd":"0000","syssn":"S1","out_trade_no":"A1"}`)(w, r)
+	})
+	res, err := c.Close(context.Background(), "A1", 1000, map[string]string{"txcurrcd": "JPY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Syssn != "S1" {
+		t.Errorf("Syssn = %q, want S1", res.Syssn)
+	}
+	if sent.Get("out_trade_no") != "A1" || sent.Get("txamt") != "10" || sent.Get("txcurrcd") != "JPY" {
+		t.Errorf("sent %v, want A1 and 10 JPY", sent)
+	}
+	if _, err := c.Close(context.Background(), "A1", 150, map[string]string{"txcurrcd": "JPY"}); ClassifyError(err) != KindValidation {
+		t.Errorf("Close of 150 cents of JPY = %v, want a ValidationError", err)
+	}
+}
+
+func TestResponseDumpMasked(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Echo-Appcode", r.Header.Get("X-QF-APPCODE"))
+		respondJSON(`{"respcd":"0000","appcode":"`+testAppCode+`"}`)(w, r)
+	})
+	req, err := c.NewFormRequest(context.Background(), "/trade/v1/query", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dump, err := req.ResponseDump(); err != nil || dump != "" {
+		t.Errorf("ResponseDump before Do = %q, %v", dump, err)
+	}
+	requestDump, err := req.DebugString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Do(); err != nil {
+		t.Fatal(err)
+	}
+	responseDump, err := req.ResponseDump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dump := range []string{requestDump, responseDump} {
+		if strings.Contains(dump, testAppCode) || !strings.Contains(dump, mask(testAppCode)) {
+			t.Errorf("app code not masked:\n%s", dump)
+		}
+	}
+	if !strings.Contains(responseDump, `"respcd":"0000"`) {
+		t.Errorf("response body not dumped:\n%s", responseDump)
+	}
+}
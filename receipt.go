@@ -0,0 +1,51 @@
+package qfpayslim
+
+import (
+	"fmt"
+	"time"
+)
+
+// Receipt is a normalized summary of a transaction, independent of QFPay's
+// field names, suitable for rendering or storing.
+type Receipt struct {
+	OrderNo    string    // merchant order number (out_trade_no)
+	Cents      int       // amount in cents
+	Currency   string    // e.g. HKD
+	PaidAt     time.Time // zero if unknown
+	ChannelRef string    // wallet/channel transaction number
+	Method     string    // pay type
+}
+
+// Receipt converts the response into a Receipt, with the amount in cents as
+// returned by AmountCents. Fields that cannot be parsed are left as zero
+// values. Times are read as Hong Kong time.
+func (res QueryResponse) Receipt() Receipt {
+	cents, _ := res.AmountCents()
+	paidAt, _ := time.ParseInLocation(DefaultTimeFormat, res.Paydtm, hongKong)
+	var ref string
+	if refs := res.ChannelReferences(); len(refs) > 0 {
+		ref = refs[0]
+	}
+	return Receipt{
+		OrderNo:    res.OutTradeNo,
+		Cents:      cents,
+		Currency:   res.Txcurrcd,
+		PaidAt:     paidAt,
+		ChannelRef: ref,
+		Method:     res.PayType,
+	}
+}
+
+func (r Receipt) String() string {
+	s := fmt.Sprintf("%s %s %d.%02d", r.OrderNo, r.Currency, r.Cents/100, r.Cents%100)
+	if !r.PaidAt.IsZero() {
+		s += " paid at " + r.PaidAt.Format(DefaultTimeFormat)
+	}
+	if r.Method != "" {
+		s += " via " + r.Method
+	}
+	if r.ChannelRef != "" {
+		s += " (" + r.ChannelRef + ")"
+	}
+	return s
+}
@@ -0,0 +1,285 @@
+package qfpayslim
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// MaxRefundNoLength is the maximum length in bytes of the order number of a
+// refund.
+const MaxRefundNoLength = 128
+
+// RefundResponse holds the information returned from QFPay API for a refund request.
+type RefundResponse struct {
+	OrigSyssn  string `json:"orig_syssn"`   // QFPay transaction number of the refunded payment
+	OutTradeNo string `json:"out_trade_no"` // API order number of the refund
+	Respcd     string `json:"respcd"`       // Refund status
+	Resperr    string `json:"resperr"`      // Refund status message
+	Respmsg    string `json:"respmsg"`      // Additional status message
+	Sysdtm     string `json:"sysdtm"`       // System transaction time
+	Syssn      string `json:"syssn"`        // QFPay transaction number of the refund
+	Txamt      string `json:"txamt"`        // Refund amount in the minor unit of the currency
+	Txdtm      string `json:"txdtm"`        // Request transaction time
+}
+
+// Refunded reports whether the refund succeeded.
+func (res RefundResponse) Refunded() bool {
+	return res.Respcd == "0000"
+}
+
+// Refund refunds cents of the payment with the QFPay transaction number
+// syssn. The refund is a new order with its own order number outTradeNo,
+// which must differ from the one of the payment, be at most
+// MaxRefundNoLength bytes and consist of letters, digits, underscores and
+// hyphens. If outTradeNo is empty, one is generated and returned in the
+// OutTradeNo of the response.
+//
+// The payment is queried first, so that a refund the wallet would reject
+// fails early with a ValidationError, as described in RefundPayment, and
+// cents is converted to the minor unit of the currency of the payment.
+// ErrOrderNotFound is returned if QFPay does not know the payment.
+func (c *Client) Refund(ctx context.Context, syssn, outTradeNo string, cents int, extra map[string]string) (*RefundResponse, error) {
+	if syssn == "" {
+		return nil, ValidationError{"syssn is required for refunds"}
+	}
+	if err := checkRefundNo(outTradeNo); err != nil {
+		return nil, err
+	}
+	payments, err := c.QuerySyssn(ctx, syssn)
+	if err != nil {
+		return nil, err
+	}
+	for _, payment := range payments {
+		if payment.Syssn == syssn && payment.OrderType != OrderTypeRefund {
+			return c.RefundPayment(ctx, payment, outTradeNo, cents, extra)
+		}
+	}
+	return nil, ErrOrderNotFound
+}
+
+// RefundPayment is like Refund but refunds the payment as returned by Query
+// without querying it again. It checks first that the refund can succeed:
+// the payment must be paid, cents must be positive and not exceed the
+// amount not refunded yet, and the refund must be within the RefundWindows
+// limit of the pay type of the payment. As in Refund, an empty outTradeNo is
+// replaced by a generated one.
+func (c *Client) RefundPayment(ctx context.Context, payment QueryResponse, outTradeNo string, cents int, extra map[string]string) (*RefundResponse, error) {
+	if payment.Syssn == "" {
+		return nil, ValidationError{"syssn is required for refunds"}
+	}
+	if err := checkRefundNo(outTradeNo); err != nil {
+		return nil, err
+	}
+	if outTradeNo == "" {
+		var err error
+		if outTradeNo, err = c.newRefundNo(); err != nil {
+			return nil, err
+		}
+	}
+	if outTradeNo == payment.OutTradeNo {
+		return nil, validationErrorf("refund order number %s must differ from the one of the payment", outTradeNo)
+	}
+	if err := c.checkRefund(payment, cents); err != nil {
+		return nil, err
+	}
+	amount, err := minorAmount(cents, payment.Txcurrcd)
+	if err != nil {
+		return nil, err
+	}
+	payload := url.Values{}
+	payload.Set("syssn", payment.Syssn)
+	payload.Set("out_trade_no", outTradeNo)
+	payload.Set("txamt", strconv.Itoa(amount))
+	payload.Set("txdtm", c.txdtm(time.Now()))
+	for k, v := range extra {
+		payload.Set(k, v)
+	}
+	req, err := c.NewFormRequest(ctx, "/trade/v1/refund", payload)
+	if err != nil {
+		return nil, err
+	}
+	var res RefundResponse
+	if err := req.Do(&res); err != nil {
+		return nil, err
+	}
+	if res.OutTradeNo == "" {
+		res.OutTradeNo = outTradeNo
+	}
+	return &res, nil
+}
+
+// checkRefundNo returns a ValidationError if outTradeNo, unless empty, is not
+// a valid refund order number.
+func checkRefundNo(outTradeNo string) error {
+	if len(outTradeNo) > MaxRefundNoLength {
+		return validationErrorf("refund order number is longer than %d bytes", MaxRefundNoLength)
+	}
+	for _, r := range outTradeNo {
+		if !(r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r == '_' || r == '-') {
+			return validationErrorf("refund order number %q contains %q, only letters, digits, _ and - are allowed", outTradeNo, r)
+		}
+	}
+	return nil
+}
+
+// newRefundNo returns a refund order number made of R, the current time in
+// the client's location and 8 random hex digits, e.g.
+// R20240102030405a1b2c3d4.
+func (c *Client) newRefundNo() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	loc := c.Location
+	if loc == nil {
+		loc = hongKong
+	}
+	return "R" + time.Now().In(loc).Format("20060102150405") + hex.EncodeToString(b), nil
+}
+
+// RefundIdempotent is like Refund but can be called again with the same
+// outTradeNo after a failure without ever refunding twice. The refund order
+// is queried first and, if QFPay already has it, its record is returned
+// instead of sending the refund again. If sending fails with a transport
+// error, after which the refund may or may not have been made, the order is
+// queried again and the refund is only sent once more if QFPay does not
+// have it. If that query fails too, the error of the refund is returned and
+// the outcome is unknown; call RefundIdempotent again later with the same
+// outTradeNo, never with a new one.
+//
+// The returned record may be of a refund that failed, so check Refunded. A
+// MismatchError is returned if outTradeNo was used to refund another payment.
+// Unlike Refund, outTradeNo is required since it identifies the refund
+// across calls.
+func (c *Client) RefundIdempotent(ctx context.Context, syssn, outTradeNo string, cents int, extra map[string]string) (*RefundResponse, error) {
+	if outTradeNo == "" {
+		return nil, ValidationError{"out_trade_no is required for idempotent refunds"}
+	}
+	existing, err := c.findRefund(ctx, syssn, outTradeNo)
+	if err != nil || existing != nil {
+		return existing, err
+	}
+	res, err := c.Refund(ctx, syssn, outTradeNo, cents, extra)
+	if err == nil || ClassifyError(err) != KindTransport {
+		return res, err
+	}
+	existing, queryErr := c.findRefund(ctx, syssn, outTradeNo)
+	if queryErr != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, nil
+	}
+	return c.Refund(ctx, syssn, outTradeNo, cents, extra)
+}
+
+// findRefund returns the refund with the order number outTradeNo, or nil if
+// QFPay does not have it.
+func (c *Client) findRefund(ctx context.Context, syssn, outTradeNo string) (*RefundResponse, error) {
+	responses, err := c.QueryByType(ctx, OrderTypeRefund, outTradeNo)
+	if err != nil {
+		return nil, err
+	}
+	for _, res := range responses {
+		if res.OutTradeNo != outTradeNo {
+			continue
+		}
+		if res.OrigSyssn != "" && res.OrigSyssn != syssn {
+			return nil, MismatchError{"orig_syssn", syssn, res.OrigSyssn}
+		}
+		refund := res.refundResponse()
+		return &refund, nil
+	}
+	return nil, nil
+}
+
+// refundResponse returns the refund record res as a RefundResponse.
+func (res QueryResponse) refundResponse() RefundResponse {
+	return RefundResponse{
+		OrigSyssn:  res.OrigSyssn,
+		OutTradeNo: res.OutTradeNo,
+		Respcd:     res.Respcd,
+		Resperr:    res.Errmsg,
+		Sysdtm:     res.Sysdtm,
+		Syssn:      res.Syssn,
+		Txamt:      res.Txamt,
+		Txdtm:      res.Txdtm,
+	}
+}
+
+// checkRefund returns a ValidationError if a refund of cents of payment
+// would be rejected.
+func (c *Client) checkRefund(payment QueryResponse, cents int) error {
+	if cents <= 0 {
+		return validationErrorf("refund amount must be positive, got %d", cents)
+	}
+	if !payment.Paid() {
+		return validationErrorf("payment %s is not paid", payment.Syssn)
+	}
+	paid, err := payment.AmountCents()
+	if err != nil {
+		return err
+	}
+	refunded, err := payment.RefundedAmountCents()
+	if err != nil {
+		return err
+	}
+	if cents > paid-refunded {
+		return validationErrorf("refund amount %d exceeds the refundable amount %d of payment %s", cents, paid-refunded, payment.Syssn)
+	}
+	if window, ok := c.RefundWindows[payment.PayType]; ok {
+		paidAt, err := time.ParseInLocation(DefaultTimeFormat, payment.Paydtm, hongKong)
+		if err != nil {
+			return err
+		}
+		if time.Since(paidAt) > window {
+			return validationErrorf("payment %s of pay type %s can only be refunded within %s of the payment", payment.Syssn, payment.PayType, window)
+		}
+	}
+	return nil
+}
+
+// RefundRecord is a refund as returned by QueryRefund.
+type RefundRecord struct {
+	OrigSyssn  string    // QFPay transaction number of the refunded payment
+	Syssn      string    // QFPay transaction number of the refund
+	OutTradeNo string    // API order number of the refund
+	Cents      int       // refund amount in cents
+	Respcd     string    // refund status, 0000 if succeeded
+	Time       time.Time // system transaction time, read as Hong Kong time
+}
+
+// Refunded reports whether the refund succeeded.
+func (r RefundRecord) Refunded() bool {
+	return r.Respcd == "0000"
+}
+
+// QueryRefund returns the refund records of the refund order numbers,
+// leaving out the payment records that Query would also return.
+func (c *Client) QueryRefund(ctx context.Context, outTradeNo ...string) ([]RefundRecord, error) {
+	responses, err := c.QueryByType(ctx, OrderTypeRefund, outTradeNo...)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]RefundRecord, 0, len(responses))
+	for _, res := range responses {
+		cents, err := res.AmountCents()
+		if err != nil {
+			return nil, err
+		}
+		t, _ := time.ParseInLocation(DefaultTimeFormat, res.Sysdtm, hongKong)
+		records = append(records, RefundRecord{
+			OrigSyssn:  res.OrigSyssn,
+			Syssn:      res.Syssn,
+			OutTradeNo: res.OutTradeNo,
+			Cents:      cents,
+			Respcd:     res.Respcd,
+			Time:       t,
+		})
+	}
+	return records, nil
+}
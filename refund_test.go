@@ -0,0 +1,181 @@
+package qfpayslim
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const refundTestPayment = `{"syssn":"S1","out_trade_no":"A1","order_type":"payment","respcd":"0000","pay_type":"800101","txamt":"1000","txcurrcd":"HKD","paydtm":"2024-01-02 03:04:05"}`
+
+// newRefundTestClient returns a client whose test server answers queries by
+// syssn with the payment, queries by order number with the refund records
+// returned by records, and refunds with refund.
+func newRefundTestClient(t *testing.T, payment string, records func() string, refund http.HandlerFunc) *Client {
+	return newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/trade/v1/refund":
+			refund(w, r)
+		case r.FormValue("syssn") != "":
+			respondJSON(`{"respcd":"0000","data":[`+payment+`]}`)(w, r)
+		default:
+			respondJSON(`{"respcd":"0000","data":[`+records()+`]}`)(w, r)
+		}
+	})
+}
+
+func noRecords() string { return "" }
+
+func TestRefund(t *testing.T) {
+	var txamt string
+	c := newRefundTestClient(t, `{"syssn":"S1","out_trade_no":"A1","respcd":"0000","txamt":"100","txcurrcd":"JPY"}`, noRecords, func(w http.ResponseWriter, r *http.Request) {
+		txamt = r.FormValue("txamt")
+		respondJSON(`{"respcd":"0000","syssn":"S2","orig_syssn":"S1","out_trade_no":"R1","txamt":"`+txamt+`"}`)(w, r)
+	})
+	res, err := c.Refund(context.Background(), "S1", "R1", 4000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Refunded() || res.Syssn != "S2" {
+		t.Errorf("res = %+v", res)
+	}
+	if txamt != "40" {
+		t.Errorf("sent txamt %s, want 40 yen", txamt)
+	}
+}
+
+func TestRefundGeneratedNo(t *testing.T) {
+	var sent []string
+	c := newRefundTestClient(t, refundTestPayment, noRecords, func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.FormValue("out_trade_no"))
+		respondJSON(`{"respcd":"0000","syssn":"S2","orig_syssn":"S1","txamt":"100"}`)(w, r)
+	})
+	for i := 0; i < 2; i++ {
+		res, err := c.Refund(context.Background(), "S1", "", 100, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.OutTradeNo != sent[i] {
+			t.Errorf("OutTradeNo = %q, want the sent %q", res.OutTradeNo, sent[i])
+		}
+		if err := checkRefundNo(sent[i]); err != nil || !strings.HasPrefix(sent[i], "R") || len(sent[i]) != 23 {
+			t.Errorf("generated refund order number %q", sent[i])
+		}
+	}
+	if sent[0] == sent[1] {
+		t.Errorf("generated the same refund order number %q twice", sent[0])
+	}
+	var validationErr ValidationError
+	if _, err := c.RefundIdempotent(context.Background(), "S1", "", 100, nil); !errors.As(err, &validationErr) {
+		t.Errorf("RefundIdempotent without order number = %v, want a ValidationError", err)
+	}
+}
+
+func TestRefundChecks(t *testing.T) {
+	tests := []struct {
+		name       string
+		payment    string
+		windows    map[string]time.Duration
+		outTradeNo string
+		cents      int
+		want       error
+	}{
+		{"not found", `{"syssn":"S9","order_type":"payment"}`, nil, "R1", 100, ErrOrderNotFound},
+		{"only a refund record", `{"syssn":"S1","order_type":"refund","respcd":"0000"}`, nil, "R1", 100, ErrOrderNotFound},
+		{"same order number", refundTestPayment, nil, "A1", 100, ValidationError{}},
+		{"invalid order number", refundTestPayment, nil, "R 1", 100, ValidationError{}},
+		{"long order number", refundTestPayment, nil, strings.Repeat("R", MaxRefundNoLength+1), 100, ValidationError{}},
+		{"not positive", refundTestPayment, nil, "R1", 0, ValidationError{}},
+		{"exceeds amount", refundTestPayment, nil, "R1", 1001, ValidationError{}},
+		{"already refunded", `{"syssn":"S1","respcd":"0000","txamt":"1000","refund_amt":"600","txcurrcd":"HKD"}`, nil, "R1", 500, ValidationError{}},
+		{"unpaid", `{"syssn":"S1","respcd":"1143","txamt":"1000","txcurrcd":"HKD"}`, nil, "R1", 100, ValidationError{}},
+		{"outside window", refundTestPayment, map[string]time.Duration{PayTypeAlipayQRCode: 24 * time.Hour}, "R1", 100, ValidationError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newRefundTestClient(t, tt.payment, noRecords, func(w http.ResponseWriter, r *http.Request) {
+				t.Error("refund sent")
+			})
+			c.RefundWindows = tt.windows
+			_, err := c.Refund(context.Background(), "S1", tt.outTradeNo, tt.cents, nil)
+			var validationErr ValidationError
+			switch tt.want.(type) {
+			case ValidationError:
+				if !errors.As(err, &validationErr) {
+					t.Errorf("err = %v, want a ValidationError", err)
+				}
+			default:
+				if !errors.Is(err, tt.want) {
+					t.Errorf("err = %v, want %v", err, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRefundIdempotent(t *testing.T) {
+	const record = `{"syssn":"S2","orig_syssn":"S1","out_trade_no":"R1","order_type":"refund","respcd":"0000","txamt":"500","txcurrcd":"HKD"}`
+	hangUp := func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}
+	refunded := respondJSON(`{"respcd":"0000","syssn":"S2","orig_syssn":"S1","out_trade_no":"R1","txamt":"500"}`)
+	tests := []struct {
+		name          string
+		before, after string // refund records before and after the first refund call
+		refunds       []http.HandlerFunc
+		wantCalls     int32
+		wantErr       bool
+	}{
+		{"already refunded", record, record, nil, 0, false},
+		{"refunded", "", record, []http.HandlerFunc{refunded}, 1, false},
+		{"lost response of a made refund", "", record, []http.HandlerFunc{hangUp}, 1, false},
+		{"lost refund", "", "", []http.HandlerFunc{hangUp, refunded}, 2, false},
+		{"business error", "", "", []http.HandlerFunc{respondJSON(`{"respcd":"1269","resperr":"Insufficient balance"}`)}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			records := func() string {
+				if atomic.LoadInt32(&calls) == 0 {
+					return tt.before
+				}
+				return tt.after
+			}
+			c := newRefundTestClient(t, refundTestPayment, records, func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				if int(n) > len(tt.refunds) {
+					t.Error("refund sent too often")
+					return
+				}
+				tt.refunds[n-1](w, r)
+			})
+			res, err := c.RefundIdempotent(context.Background(), "S1", "R1", 500, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (res == nil || res.Syssn != "S2" || !res.Refunded()) {
+				t.Errorf("res = %+v", res)
+			}
+			if n := atomic.LoadInt32(&calls); n != tt.wantCalls {
+				t.Errorf("refund sent %d times, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRefundIdempotentMismatch(t *testing.T) {
+	other := `{"syssn":"S8","orig_syssn":"S7","out_trade_no":"R1","order_type":"refund","respcd":"0000"}`
+	c := newRefundTestClient(t, refundTestPayment, func() string { return other }, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("refund sent")
+	})
+	_, err := c.RefundIdempotent(context.Background(), "S1", "R1", 500, nil)
+	var mismatch MismatchError
+	if !errors.As(err, &mismatch) || mismatch.Field != "orig_syssn" {
+		t.Errorf("err = %v, want an orig_syssn MismatchError", err)
+	}
+}
@@ -0,0 +1,88 @@
+package qfpayslim
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. The delay before
+// each retry doubles from BaseDelay and is capped at MaxDelay. Queries are
+// retried after any transport error, but requests that change data, such as
+// payments and refunds, only if the connection could not be made, since
+// resending one that reached QFPay fails as a duplicate order.
+type RetryPolicy struct {
+	MaxAttempts int           // total number of attempts, including the first one
+	BaseDelay   time.Duration // delay before the first retry
+	MaxDelay    time.Duration // upper bound of the delay, zero for no bound
+	Jitter      bool          // randomize each delay between half and full length
+}
+
+// DefaultRetryPolicy returns a policy of 3 attempts with delays starting at
+// 500 milliseconds and capped at 5 seconds.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+		Jitter:      true,
+	}
+}
+
+// nextDelay returns the delay to wait after the given failed attempt,
+// starting with 1.
+func (p *RetryPolicy) nextDelay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter && d > 1 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+	return d
+}
+
+// idempotentPaths lists the endpoints that only read data, so that sending
+// their requests twice is harmless.
+var idempotentPaths = []string{"/trade/v1/query"}
+
+// retryable reports whether a request that failed with err on the given
+// attempt should be sent again. QFError codes listed in RetryableCodes are
+// retried. Transport errors are only retried for requests to
+// idempotentPaths, GET and HEAD requests, or if the connection could not be
+// made: otherwise the request may have reached QFPay with only the response
+// lost, and sending a payment or refund again would fail as a duplicate
+// order although the first one succeeded.
+func (req *Request) retryable(err error, attempt int) bool {
+	c := req.client
+	if c.Retry == nil || attempt >= c.Retry.MaxAttempts {
+		return false
+	}
+	switch e := err.(type) {
+	case nil, HTMLResponseError:
+		return false
+	case QFError:
+		return contains(c.RetryableCodes, e.Code)
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return req.idempotent() || notSent(err)
+}
+
+// idempotent reports whether the request can be sent more than once.
+func (req *Request) idempotent() bool {
+	return req.Method == "GET" || req.Method == "HEAD" || contains(idempotentPaths, req.URL.Path)
+}
+
+// notSent reports whether err means that the request never left the client,
+// because the connection could not be made.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,121 @@
+package qfpayslim
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errTest = errors.New("test error")
+
+func TestNextDelay(t *testing.T) {
+	p := &RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	want := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i, w := range want {
+		if got := p.nextDelay(i + 1); got != w {
+			t.Errorf("nextDelay(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+	if got := (&RetryPolicy{BaseDelay: time.Second}).nextDelay(5); got != 16*time.Second {
+		t.Errorf("nextDelay(5) without MaxDelay = %s, want 16s", got)
+	}
+}
+
+func TestNextDelayJitter(t *testing.T) {
+	p := &RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: true}
+	for attempt := 1; attempt <= 6; attempt++ {
+		full := (&RetryPolicy{BaseDelay: p.BaseDelay, MaxDelay: p.MaxDelay}).nextDelay(attempt)
+		for i := 0; i < 100; i++ {
+			if got := p.nextDelay(attempt); got < full/2 || got > full {
+				t.Fatalf("nextDelay(%d) = %s, want between %s and %s", attempt, got, full/2, full)
+			}
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	c := &Client{Prefix: "https://test.qfapi.com", Retry: &RetryPolicy{MaxAttempts: 3}, RetryableCodes: []string{"1143"}}
+	dialErr := &url.Error{Op: "Post", URL: c.Prefix, Err: &net.OpError{Op: "dial", Net: "tcp", Err: errTest}}
+	readErr := &url.Error{Op: "Post", URL: c.Prefix, Err: &net.OpError{Op: "read", Net: "tcp", Err: errTest}}
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		err     error
+		attempt int
+		want    bool
+	}{
+		{"success", "POST", "/trade/v1/query", nil, 1, false},
+		{"query transport error", "POST", "/trade/v1/query", readErr, 1, true},
+		{"GET transport error", "GET", "/trade/v1/custom", errTest, 1, true},
+		{"last attempt", "POST", "/trade/v1/query", readErr, 3, false},
+		{"payment response lost", "POST", "/trade/v1/payment", readErr, 1, false},
+		{"refund response lost", "POST", "/trade/v1/refund", errTest, 1, false},
+		{"payment not sent", "POST", "/trade/v1/payment", dialErr, 1, true},
+		{"canceled", "POST", "/trade/v1/query", context.Canceled, 1, false},
+		{"retryable code", "POST", "/trade/v1/payment", QFError{Code: "1143"}, 1, true},
+		{"other code", "POST", "/trade/v1/query", QFError{Code: "1108"}, 1, false},
+		{"HTML response", "POST", "/trade/v1/query", HTMLResponseError{StatusCode: 502}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := c.NewRequest(context.Background(), tt.method, tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := req.retryable(tt.err, tt.attempt); got != tt.want {
+				t.Errorf("retryable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPaymentResponseLost(t *testing.T) {
+	var attempts int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	})
+	c.Retry = &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	req, err := c.MakePayment(context.Background(), PayTypeAlipayQRCode, "A1", "Goods", 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Do(); ClassifyError(err) != KindTransport {
+		t.Errorf("err = %v, want a transport error", err)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Errorf("payment sent %d times, want 1", n)
+	}
+}
+
+func TestRetryNotSent(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	prefix := srv.URL
+	srv.Close() // nothing listens at prefix any more
+	c := &Client{Prefix: prefix, AllowInsecure: true, Retry: &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}}
+	req, err := c.MakePayment(context.Background(), PayTypeAlipayQRCode, "A1", "Goods", 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Do(); ClassifyError(err) != KindTransport {
+		t.Errorf("err = %v, want a transport error", err)
+	}
+	if req.Attempts() != 3 {
+		t.Errorf("Attempts = %d, want 3", req.Attempts())
+	}
+}
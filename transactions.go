@@ -0,0 +1,163 @@
+package qfpayslim
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// txnPageSize is the number of transactions requested per page, the maximum
+// allowed by QFPay.
+const txnPageSize = 100
+
+// TxnIterator iterates over the transactions in a time range. Pages are
+// fetched lazily as the caller advances.
+type TxnIterator struct {
+	client *Client
+	ctx    context.Context
+	from   time.Time
+	to     time.Time
+	page   int
+	buf    []QueryResponse
+	count  int
+	done   bool
+	err    error
+}
+
+// PaginationError is returned by TxnIterator.Err when the number of
+// transactions fetched differs from the total reported by QFPay, which means
+// records were lost or duplicated between pages.
+type PaginationError struct {
+	Total   int // total reported by QFPay
+	Fetched int // number of transactions fetched
+}
+
+func (e PaginationError) Error() string {
+	return "qfpayslim: fetched " + strconv.Itoa(e.Fetched) + " transactions, expected " + strconv.Itoa(e.Total)
+}
+
+// TransactionIterator returns an iterator over the transactions made
+// between from and to.
+//
+//	it := qfpay.TransactionIterator(ctx, from, to)
+//	for res, ok := it.Next(); ok; res, ok = it.Next() {
+//		// ...
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+func (c *Client) TransactionIterator(ctx context.Context, from, to time.Time) *TxnIterator {
+	return &TxnIterator{client: c, ctx: ctx, from: from, to: to}
+}
+
+// Next returns the next transaction. It returns false when there are no more
+// transactions or an error occurred, which is then returned by Err.
+func (it *TxnIterator) Next() (QueryResponse, bool) {
+	for len(it.buf) == 0 {
+		if it.done || it.err != nil {
+			return QueryResponse{}, false
+		}
+		it.fetch()
+	}
+	res := it.buf[0]
+	it.buf = it.buf[1:]
+	return res, true
+}
+
+// Err returns the error that stopped the iteration, if any. If the response
+// reports a total, a PaginationError is returned after the last page when
+// the number of transactions fetched does not match it.
+func (it *TxnIterator) Err() error {
+	return it.err
+}
+
+func (it *TxnIterator) fetch() {
+	it.page++
+	payload := url.Values{}
+	payload.Set("start_time", it.client.txdtm(it.from))
+	payload.Set("end_time", it.client.txdtm(it.to))
+	payload.Set("page", strconv.Itoa(it.page))
+	payload.Set("page_size", strconv.Itoa(txnPageSize))
+	req, err := it.client.NewFormRequest(it.ctx, "/trade/v1/query", payload)
+	if err != nil {
+		it.err = err
+		return
+	}
+	var b []byte
+	if err := req.Do(&b); err != nil {
+		it.err = err
+		return
+	}
+	var responses []QueryResponse
+	if err := it.client.decodePath(b, &responses, "data.*"); err != nil {
+		it.err = err
+		return
+	}
+	// the total is not reported by every version of the API
+	var total json.Number
+	it.client.decodePath(b, &total, "total")
+	it.buf = responses
+	it.count += len(responses)
+	it.done = len(responses) < txnPageSize
+	if it.done && total != "" {
+		if n, err := total.Int64(); err == nil && int(n) != it.count {
+			it.err = PaginationError{int(n), it.count}
+		}
+	}
+}
+
+// Money is an amount in cents of a currency.
+type Money struct {
+	Cents    int
+	Currency string
+}
+
+// DailyBreakdown details the transactions that make up a daily net amount.
+type DailyBreakdown struct {
+	Paid     Money // total of successful payments
+	Refunded Money // total of successful refunds
+	Payments int   // number of successful payments
+	Refunds  int   // number of successful refunds
+}
+
+// DailyNet returns the amount paid minus the amount refunded on the day of
+// date in the client's location, counting only successful transactions in
+// currency, along with the breakdown of the figure.
+func (c *Client) DailyNet(ctx context.Context, date time.Time, currency string) (Money, DailyBreakdown, error) {
+	loc := c.Location
+	if loc == nil {
+		loc = hongKong
+	}
+	y, m, d := date.In(loc).Date()
+	from := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	to := from.AddDate(0, 0, 1).Add(-time.Second)
+	breakdown := DailyBreakdown{
+		Paid:     Money{Currency: currency},
+		Refunded: Money{Currency: currency},
+	}
+	it := c.TransactionIterator(ctx, from, to)
+	for res, ok := it.Next(); ok; res, ok = it.Next() {
+		if !res.Paid() || res.Txcurrcd != currency {
+			continue
+		}
+		cents, err := res.AmountCents()
+		if err != nil {
+			return Money{}, DailyBreakdown{}, err
+		}
+		switch res.OrderType {
+		case OrderTypePayment:
+			breakdown.Paid.Cents += cents
+			breakdown.Payments++
+		case OrderTypeRefund:
+			breakdown.Refunded.Cents += cents
+			breakdown.Refunds++
+		}
+	}
+	if err := it.Err(); err != nil {
+		return Money{}, DailyBreakdown{}, err
+	}
+	net := Money{breakdown.Paid.Cents - breakdown.Refunded.Cents, currency}
+	return net, breakdown, nil
+}
@@ -0,0 +1,193 @@
+package qfpayslim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// txnHandler serves records in pages as the transaction list endpoint does,
+// reporting total if it is not empty, and counts the pages requested. A
+// page listed in fail is answered with an error.
+func txnHandler(t *testing.T, records []string, total string, pages *int32, fail ...int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(pages, 1)
+		page, _ := strconv.Atoi(r.FormValue("page"))
+		size, _ := strconv.Atoi(r.FormValue("page_size"))
+		if r.FormValue("start_time") == "" || r.FormValue("end_time") == "" {
+			t.Error("time range not sent")
+		}
+		for _, n := range fail {
+			if n == page {
+				respondJSON(`{"respcd":"1108","resperr":"Invalid parameters"}`)(w, r)
+				return
+			}
+		}
+		start, end := (page-1)*size, page*size
+		if start > len(records) {
+			start = len(records)
+		}
+		if end > len(records) {
+			end = len(records)
+		}
+		body := `{"respcd":"0000","data":[` + strings.Join(records[start:end], ",") + `]`
+		if total != "" {
+			body += `,"total":` + total
+		}
+		respondJSON(body+"}")(w, r)
+	}
+}
+
+// txnRecords returns n paid HKD payments of 100 cents with the order numbers
+// T0, T1, ...
+func txnRecords(n int) []string {
+	records := make([]string, n)
+	for i := range records {
+		records[i] = fmt.Sprintf(`{"out_trade_no":"T%d","order_type":"payment","respcd":"0000","txamt":"100","txcurrcd":"HKD"}`, i)
+	}
+	return records
+}
+
+func TestTransactionIterator(t *testing.T) {
+	from := time.Date(2024, 1, 2, 0, 0, 0, 0, hongKong)
+	to := from.AddDate(0, 0, 1)
+	tests := []struct {
+		name      string
+		records   int
+		wantPages int32
+	}{
+		{"no transactions", 0, 1},
+		{"one page", 30, 1},
+		{"several pages", 250, 3},
+		{"full last page", 200, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pages int32
+			c := newTestClient(t, txnHandler(t, txnRecords(tt.records), "", &pages))
+			it := c.TransactionIterator(context.Background(), from, to)
+			n := 0
+			for res, ok := it.Next(); ok; res, ok = it.Next() {
+				if want := "T" + strconv.Itoa(n); res.OutTradeNo != want {
+					t.Fatalf("transaction %d is %s, want %s", n, res.OutTradeNo, want)
+				}
+				n++
+			}
+			if err := it.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.records {
+				t.Errorf("got %d transactions, want %d", n, tt.records)
+			}
+			if _, ok := it.Next(); ok {
+				t.Error("Next returned a transaction after the last one")
+			}
+			if got := atomic.LoadInt32(&pages); got != tt.wantPages {
+				t.Errorf("fetched %d pages, want %d", got, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestTransactionIteratorError(t *testing.T) {
+	var pages int32
+	c := newTestClient(t, txnHandler(t, txnRecords(250), "", &pages, 2))
+	it := c.TransactionIterator(context.Background(), time.Now().Add(-time.Hour), time.Now())
+	n := 0
+	for _, ok := it.Next(); ok; _, ok = it.Next() {
+		n++
+	}
+	if n != txnPageSize {
+		t.Errorf("got %d transactions, want the %d of the first page", n, txnPageSize)
+	}
+	if ClassifyError(it.Err()) != KindBusiness {
+		t.Errorf("Err = %v, want the QFError of the second page", it.Err())
+	}
+	if _, ok := it.Next(); ok {
+		t.Error("Next returned a transaction after an error")
+	}
+	if n := atomic.LoadInt32(&pages); n != 2 {
+		t.Errorf("fetched %d pages, want 2", n)
+	}
+}
+
+func TestTransactionIteratorTotal(t *testing.T) {
+	tests := []struct {
+		name  string
+		total string
+		want  error
+	}{
+		{"no total", "", nil},
+		{"matching total", "250", nil},
+		{"matching string total", `"250"`, nil},
+		{"pages lost", "300", PaginationError{Total: 300, Fetched: 250}},
+		{"records duplicated", "200", PaginationError{Total: 200, Fetched: 250}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pages int32
+			c := newTestClient(t, txnHandler(t, txnRecords(250), tt.total, &pages))
+			it := c.TransactionIterator(context.Background(), time.Now().Add(-time.Hour), time.Now())
+			n := 0
+			for _, ok := it.Next(); ok; _, ok = it.Next() {
+				n++
+			}
+			if n != 250 {
+				t.Errorf("got %d transactions, want 250", n)
+			}
+			if err := it.Err(); err != tt.want {
+				t.Errorf("Err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDailyNet(t *testing.T) {
+	records := []string{
+		`{"order_type":"payment","respcd":"0000","txamt":"1000","txcurrcd":"HKD"}`,
+		`{"order_type":"payment","respcd":"0000","txamt":"550","txcurrcd":"HKD"}`,
+		`{"order_type":"payment","respcd":"1143","txamt":"9999","txcurrcd":"HKD"}`,
+		`{"order_type":"payment","respcd":"0000","txamt":"500","txcurrcd":"CNY"}`,
+		`{"order_type":"refund","respcd":"0000","txamt":"300","txcurrcd":"HKD"}`,
+		`{"order_type":"refund","respcd":"1269","txamt":"200","txcurrcd":"HKD"}`,
+	}
+	var pages int32
+	var startTime, endTime string
+	handler := txnHandler(t, records, "6", &pages)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		startTime, endTime = r.FormValue("start_time"), r.FormValue("end_time")
+		handler(w, r)
+	})
+	date := time.Date(2024, 1, 2, 20, 0, 0, 0, time.UTC) // 2024-01-03 in Hong Kong
+	net, breakdown, err := c.DailyNet(context.Background(), date, "HKD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if startTime != "2024-01-03 00:00:00" || endTime != "2024-01-03 23:59:59" {
+		t.Errorf("sent the range %s to %s", startTime, endTime)
+	}
+	if want := (Money{1250, "HKD"}); net != want {
+		t.Errorf("net = %+v, want %+v", net, want)
+	}
+	want := DailyBreakdown{Paid: Money{1550, "HKD"}, Refunded: Money{300, "HKD"}, Payments: 2, Refunds: 1}
+	if breakdown != want {
+		t.Errorf("breakdown = %+v, want %+v", breakdown, want)
+	}
+
+	jpy := newTestClient(t, txnHandler(t, []string{`{"order_type":"payment","respcd":"0000","txamt":"15","txcurrcd":"JPY"}`}, "", &pages))
+	if net, _, err := jpy.DailyNet(context.Background(), date, "JPY"); err != nil || net != (Money{1500, "JPY"}) {
+		t.Errorf("JPY net = %+v, %v, want 1500 cents", net, err)
+	}
+
+	lost := newTestClient(t, txnHandler(t, records, "7", &pages))
+	var paginationErr PaginationError
+	if _, _, err := lost.DailyNet(context.Background(), date, "HKD"); !errors.As(err, &paginationErr) {
+		t.Errorf("err = %v, want a PaginationError", err)
+	}
+}
@@ -0,0 +1,155 @@
+package qfpayslim
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TransportTimeouts bounds the phases of a request separately from the
+// overall timeout of the http.Client. Zero values keep the defaults of
+// http.DefaultTransport.
+//
+// For the QFPay gateway, 5 seconds for DialTimeout and TLSHandshakeTimeout
+// and 20 seconds for ResponseHeaderTimeout leave room for slow wallet
+// backends while failing fast on network problems.
+type TransportTimeouts struct {
+	DialTimeout           time.Duration // establishing the TCP connection
+	TLSHandshakeTimeout   time.Duration // completing the TLS handshake
+	ResponseHeaderTimeout time.Duration // waiting for the response headers after sending the request
+}
+
+// DefaultTransport returns a copy of http.DefaultTransport with the given
+// timeouts, to be used with Client.HTTPClient:
+//
+//	qfpay.HTTPClient = &http.Client{
+//		Timeout: 30 * time.Second,
+//		Transport: qfpayslim.DefaultTransport(qfpayslim.TransportTimeouts{
+//			DialTimeout:           5 * time.Second,
+//			TLSHandshakeTimeout:   5 * time.Second,
+//			ResponseHeaderTimeout: 20 * time.Second,
+//		}),
+//	}
+func DefaultTransport(timeouts TransportTimeouts) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if timeouts.DialTimeout > 0 {
+		t.DialContext = (&net.Dialer{
+			Timeout:   timeouts.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if timeouts.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = timeouts.TLSHandshakeTimeout
+	}
+	if timeouts.ResponseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = timeouts.ResponseHeaderTimeout
+	}
+	return t
+}
+
+// SigningTransport is an http.RoundTripper that adds the X-QF-APPCODE,
+// X-QF-SIGN and X-QF-SIGNTYPE headers to every request, signing it with the
+// Client's key. As in NewSignedRequest, the query string is signed for GET,
+// HEAD and DELETE and the form body otherwise, so it only works for
+// requests with a form-encoded body or no body at all.
+//
+//	httpClient := &http.Client{Transport: qfpayslim.SigningTransport{Client: qfpay}}
+//	res, err := httpClient.PostForm(qfpay.Prefix+"/trade/v1/query", values)
+//	res, err = httpClient.Get(qfpay.Prefix + "/trade/v1/query?out_trade_no=A1")
+type SigningTransport struct {
+	Client *Client
+	Base   http.RoundTripper // defaults to http.DefaultTransport
+}
+
+func (t SigningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var payload url.Values
+	r := req.Clone(req.Context())
+	switch req.Method {
+	case "", "GET", "HEAD", "DELETE":
+		payload = req.URL.Query()
+	}
+	if payload == nil && req.Body != nil && req.Body != http.NoBody {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		if payload, err = url.ParseQuery(string(b)); err != nil {
+			return nil, err
+		}
+	}
+	r.Header.Set("X-QF-APPCODE", t.Client.AppCode)
+	r.Header.Set("X-QF-SIGN", t.Client.GenerateSign(payload))
+	r.Header.Set("X-QF-SIGNTYPE", "MD5")
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
+
+// Warmup sends a HEAD request to Prefix so that a TLS connection to QFPay
+// is established and kept in the pool before the first payment, taking the
+// handshake off the critical path. The response status is ignored. It is
+// safe to call repeatedly, e.g. periodically to keep the connection alive.
+// It only helps with a transport that keeps connections alive, such as the
+// one returned by DefaultTransport.
+func (c *Client) Warmup(ctx context.Context) error {
+	req, err := c.NewRequest(ctx, "HEAD", "", nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.httpClient().Do(req.Request)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	return res.Body.Close()
+}
+
+// ErrCertificateNotPinned is returned when connecting to a server whose
+// certificate chain matches none of the pins of PinnedTransport.
+var ErrCertificateNotPinned = errors.New("qfpayslim: server certificate does not match any pin")
+
+// PinnedTransport returns a copy of t that only connects to servers whose
+// certificate chain contains a public key matching one of pins, which are
+// base64-encoded SHA-256 hashes of the DER-encoded SubjectPublicKeyInfo, the
+// same format as HTTP public key pinning. A pin can be obtained with:
+//
+//	openssl s_client -connect openapi-hk.qfapi.com:443 </dev/null 2>/dev/null |
+//		openssl x509 -pubkey -noout |
+//		openssl pkey -pubin -outform der |
+//		openssl dgst -sha256 -binary | base64
+//
+// Pinning an intermediate or root CA key survives certificate renewals
+// better than pinning the leaf. To rotate, add the new pin next to the old
+// one, deploy, and remove the old pin only after QFPay has switched.
+// The usual certificate verification still applies.
+func PinnedTransport(t *http.Transport, pins ...string) *http.Transport {
+	t = t.Clone()
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		for _, chain := range cs.VerifiedChains {
+			for _, cert := range chain {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if contains(pins, base64.StdEncoding.EncodeToString(sum[:])) {
+					return nil
+				}
+			}
+		}
+		return ErrCertificateNotPinned
+	}
+	return t
+}
@@ -0,0 +1,54 @@
+package qfpayslim
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSigningTransport(t *testing.T) {
+	values := url.Values{"out_trade_no": {"A1"}, "txamt": {"100"}}
+	want := md5Sign("out_trade_no=A1&txamt=100" + testKey)
+	var gotSign, gotAppCode, gotBody string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotSign, gotAppCode, gotBody = r.Header.Get("X-QF-SIGN"), r.Header.Get("X-QF-APPCODE"), string(b)
+		respondJSON(`{"respcd":"0000"}`)(w, r)
+	})
+	httpClient := &http.Client{Transport: SigningTransport{Client: c}}
+	send := map[string]func() (*http.Response, error){
+		"GET": func() (*http.Response, error) {
+			return httpClient.Get(c.Prefix + "/trade/v1/query?" + values.Encode())
+		},
+		"POST": func() (*http.Response, error) {
+			return httpClient.PostForm(c.Prefix+"/trade/v1/query", values)
+		},
+		"DELETE with a body": func() (*http.Response, error) {
+			req, err := http.NewRequest("DELETE", c.Prefix+"/trade/v1/query?"+values.Encode(), strings.NewReader("ignored=1"))
+			if err != nil {
+				return nil, err
+			}
+			return httpClient.Do(req)
+		},
+	}
+	for name, fn := range send {
+		t.Run(name, func(t *testing.T) {
+			res, err := fn()
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if gotSign != want {
+				t.Errorf("X-QF-SIGN = %s, want %s", gotSign, want)
+			}
+			if gotAppCode != testAppCode {
+				t.Errorf("X-QF-APPCODE = %s", gotAppCode)
+			}
+			if name == "POST" && gotBody != values.Encode() {
+				t.Errorf("body = %q, want %q", gotBody, values.Encode())
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package qfpayslim
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrPaymentTimeout is returned by WaitForPaymentTimeout when the order is
+// still unpaid after the maximum wait.
+var ErrPaymentTimeout = errors.New("qfpayslim: payment not completed in time")
+
+// WaitForPayment queries the order every interval until it is paid, as
+// reported by IsPaid, and returns its paid record. It stops with the error of ctx when ctx is done.
+// Queries that fail with a transport error, or a QFError listed in
+// RetryableCodes, are tried again at the next interval; any other error,
+// such as an invalid signature, is returned right away.
+func (c *Client) WaitForPayment(ctx context.Context, outTradeNo string, interval time.Duration) (QueryResponse, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		responses, err := c.QueryByType(ctx, OrderTypePayment, outTradeNo)
+		if ctx.Err() != nil {
+			return QueryResponse{}, ctx.Err()
+		}
+		if err != nil && !c.pollable(err) {
+			return QueryResponse{}, err
+		}
+		for _, res := range responses {
+			if res.OutTradeNo == outTradeNo && c.IsPaid(res) {
+				return res, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return QueryResponse{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitForPaymentTimeout is like WaitForPayment but gives up after maxWait,
+// even if ctx has a later deadline, so that abandoned QR codes can be
+// dropped cleanly. It returns ErrPaymentTimeout if maxWait expires first,
+// and the error of ctx if ctx is done first.
+func (c *Client) WaitForPaymentTimeout(ctx context.Context, outTradeNo string, interval, maxWait time.Duration) (QueryResponse, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
+	res, err := c.WaitForPayment(waitCtx, outTradeNo, interval)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return res, ErrPaymentTimeout
+	}
+	return res, err
+}
+
+// pollable reports whether a query that failed with err can be tried again
+// by WaitForPayment.
+func (c *Client) pollable(err error) bool {
+	var qfErr QFError
+	if errors.As(err, &qfErr) {
+		return contains(c.RetryableCodes, qfErr.Code)
+	}
+	return ClassifyError(err) == KindTransport
+}
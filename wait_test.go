@@ -0,0 +1,113 @@
+package qfpayslim
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pollingHandler answers each query with the next of responses, repeating
+// the last one, and counts the queries.
+func pollingHandler(queries *int32, responses ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(queries, 1))
+		if n > len(responses) {
+			n = len(responses)
+		}
+		respondJSON(responses[n-1])(w, r)
+	}
+}
+
+const (
+	unpaidResponse = `{"respcd":"0000","data":[{"out_trade_no":"A1","order_type":"payment","respcd":"1143"}]}`
+	paidResponse   = `{"respcd":"0000","data":[{"out_trade_no":"A1","order_type":"payment","respcd":"0000","syssn":"S1"}]}`
+)
+
+func TestWaitForPayment(t *testing.T) {
+	var queries int32
+	c := newTestClient(t, pollingHandler(&queries, unpaidResponse, `{"respcd":"1143","resperr":"In progress"}`, paidResponse))
+	c.RetryableCodes = []string{"1143"}
+	res, err := c.WaitForPayment(context.Background(), "A1", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Syssn != "S1" {
+		t.Errorf("Syssn = %q, want S1", res.Syssn)
+	}
+	if n := atomic.LoadInt32(&queries); n != 3 {
+		t.Errorf("queries = %d, want 3", n)
+	}
+}
+
+func TestWaitForPaymentPermanentError(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		codes    []string
+	}{
+		{"invalid parameters", `{"respcd":"1108","resperr":"Invalid parameters"}`, []string{"1143"}},
+		{"code not retryable", `{"respcd":"1143","resperr":"In progress"}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queries int32
+			c := newTestClient(t, pollingHandler(&queries, tt.response, paidResponse))
+			c.RetryableCodes = tt.codes
+			_, err := c.WaitForPaymentTimeout(context.Background(), "A1", time.Millisecond, time.Second)
+			if ClassifyError(err) != KindBusiness {
+				t.Errorf("err = %v, want a QFError", err)
+			}
+			if n := atomic.LoadInt32(&queries); n != 1 {
+				t.Errorf("queries = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestWaitForPaymentTimeout(t *testing.T) {
+	var queries int32
+	c := newTestClient(t, pollingHandler(&queries, unpaidResponse))
+
+	_, err := c.WaitForPaymentTimeout(context.Background(), "A1", 5*time.Millisecond, 50*time.Millisecond)
+	if !errors.Is(err, ErrPaymentTimeout) {
+		t.Errorf("maxWait expired: err = %v, want ErrPaymentTimeout", err)
+	}
+	if n := atomic.LoadInt32(&queries); n < 2 {
+		t.Errorf("queries = %d, want the order polled", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.WaitForPaymentTimeout(ctx, "A1", 5*time.Millisecond, time.Minute)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrPaymentTimeout) {
+		t.Errorf("ctx expired: err = %v, want context.DeadlineExceeded", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = c.WaitForPaymentTimeout(ctx, "A1", 5*time.Millisecond, time.Minute)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ctx canceled: err = %v, want context.Canceled", err)
+	}
+}
+
+func TestWaitForPaymentPaidCodes(t *testing.T) {
+	const settled = `{"respcd":"0000","data":[{"out_trade_no":"A1","order_type":"payment","respcd":"1144","syssn":"S1"}]}`
+	var queries int32
+	c := newTestClient(t, pollingHandler(&queries, unpaidResponse, settled))
+	if _, err := c.WaitForPaymentTimeout(context.Background(), "A1", time.Millisecond, 50*time.Millisecond); !errors.Is(err, ErrPaymentTimeout) {
+		t.Errorf("without PaidCodes: err = %v, want ErrPaymentTimeout", err)
+	}
+	atomic.StoreInt32(&queries, 0)
+	c.PaidCodes = []string{"0000", "1144"}
+	res, err := c.WaitForPaymentTimeout(context.Background(), "A1", time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Respcd != "1144" || !c.IsPaid(res) {
+		t.Errorf("res = %+v, want the record paid with 1144", res)
+	}
+}